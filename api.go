@@ -0,0 +1,143 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// deckInfo is the /api/v1/deck response.
+type deckInfo struct {
+	Cards        []string          `json:"cards"`
+	Count        int               `json:"count"`
+	UniqueCount  int               `json:"uniqueCount"`
+	Digest       string            `json:"digest"`
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// cardInfo is the /api/v1/cards/{shorthand} response.
+type cardInfo struct {
+	Shorthand string `json:"shorthand"`
+	Filename  string `json:"filename"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"mediaType"`
+	Count     int    `json:"count"` // times the card appears in the deck
+	URL       string `json:"url"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// cardLayer returns the image layer for shorthand. Layers are matched by the
+// card annotation, or by filename for decks pushed before it existed.
+func (ds *deckServer) cardLayer(shorthand string) (ocispec.Descriptor, bool) {
+	filename, _ := shorthandToFilename(shorthand)
+	for _, layer := range ds.manifest.Layers {
+		if layer.Annotations[cardAnnotation] == shorthand ||
+			(filename != "" && layer.Annotations[ocispec.AnnotationTitle] == filename) {
+			return layer, true
+		}
+	}
+	return ocispec.Descriptor{}, false
+}
+
+// imageURL returns the path the image of shorthand is served at, or "" when
+// the deck has no image for it.
+func (ds *deckServer) imageURL(shorthand string) string {
+	layer, ok := ds.cardLayer(shorthand)
+	if !ok {
+		return ""
+	}
+	return ds.base + "/images/" + layer.Annotations[ocispec.AnnotationTitle]
+}
+
+// blobURL returns the digest URL of the image of shorthand, or "" when the
+// deck has no image for it.
+func (ds *deckServer) blobURL(shorthand string) string {
+	layer, ok := ds.cardLayer(shorthand)
+	if !ok {
+		return ""
+	}
+	return ds.base + "/blobs/" + layer.Digest.String()
+}
+
+func (ds *deckServer) handleAPIDeck(w http.ResponseWriter, r *http.Request) {
+	unique := make(map[string]bool)
+	for _, c := range ds.cards {
+		unique[c] = true
+	}
+	writeJSON(w, http.StatusOK, deckInfo{
+		Cards:        ds.cards,
+		Count:        len(ds.cards),
+		UniqueCount:  len(unique),
+		Digest:       ds.manifestDesc.Digest.String(),
+		MediaType:    ds.manifestDesc.MediaType,
+		ArtifactType: deckArtifactType(ds.manifest),
+		Annotations:  ds.manifest.Annotations,
+	})
+}
+
+func (ds *deckServer) handleAPICard(w http.ResponseWriter, r *http.Request) {
+	shorthand := r.PathValue("shorthand")
+	count := 0
+	for _, c := range ds.cards {
+		if c == shorthand {
+			count++
+		}
+	}
+	layer, ok := ds.cardLayer(shorthand)
+	if count == 0 || !ok {
+		writeJSONError(w, http.StatusNotFound, "card "+shorthand+" is not in the deck")
+		return
+	}
+	filename := layer.Annotations[ocispec.AnnotationTitle]
+	writeJSON(w, http.StatusOK, cardInfo{
+		Shorthand: shorthand,
+		Filename:  filename,
+		Digest:    layer.Digest.String(),
+		Size:      layer.Size,
+		MediaType: layer.MediaType,
+		Count:     count,
+		URL:       ds.imageURL(shorthand),
+	})
+}
+
+// handleAPIManifest serves the deck manifest exactly as it was fetched.
+func (ds *deckServer) handleAPIManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ds.manifestDesc.MediaType)
+	w.Header().Set("Docker-Content-Digest", ds.manifestDesc.Digest.String())
+	w.Write(ds.manifestBytes)
+}
+
+// handleAPIConfig serves the deck config exactly as it was fetched.
+func (ds *deckServer) handleAPIConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ds.manifest.Config.MediaType)
+	w.Header().Set("Docker-Content-Digest", ds.manifest.Config.Digest.String())
+	w.Write(ds.configBytes)
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// newTestDeckHandler saves cards to a layout and returns a handler serving them.
+func newTestDeckHandler(t *testing.T, cards []string) (*deckHandler, *deckServer) {
+	t.Helper()
+	ctx := context.Background()
+	layout := filepath.Join(t.TempDir(), "layout")
+	if err := saveDeckLocal(ctx, layout, writeDeckFile(t, cards), "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	src, tag, err := openDeck(ctx, layout, registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := loadDeck(ctx, src, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newDeckHandler()
+	h.deck.Store(ds)
+	return h, ds
+}
+
+func getJSON(t *testing.T, h http.Handler, path string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: decoding %q: %v", path, w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+func TestAPIDeck(t *testing.T) {
+	h, ds := newTestDeckHandler(t, []string{"2c", "ad", "2c"})
+
+	var deck deckInfo
+	w := getJSON(t, h, "/api/v1/deck", &deck)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /api/v1/deck = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if len(deck.Cards) != 3 || deck.Cards[2] != "2c" || deck.Count != 3 || deck.UniqueCount != 2 {
+		t.Errorf("deck = %+v, want [2c ad 2c] with 2 unique", deck)
+	}
+	if deck.Digest != ds.manifestDesc.Digest.String() || deck.ArtifactType != artifactType {
+		t.Errorf("deck digest/type = %s %s", deck.Digest, deck.ArtifactType)
+	}
+	if deck.Annotations[ocispec.AnnotationCreated] == "" {
+		t.Errorf("deck annotations = %v, want created time", deck.Annotations)
+	}
+}
+
+func TestAPICard(t *testing.T) {
+	h, _ := newTestDeckHandler(t, []string{"2c", "ad", "2c"})
+
+	var card cardInfo
+	w := getJSON(t, h, "/api/v1/cards/2c", &card)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/v1/cards/2c = %d: %s", w.Code, w.Body)
+	}
+	if card.Filename != "2_of_clubs.png" || card.MediaType != "image/png" || card.Count != 2 || card.URL != "/images/2_of_clubs.png" {
+		t.Errorf("card = %+v", card)
+	}
+	img := httptest.NewRecorder()
+	h.ServeHTTP(img, httptest.NewRequest("GET", card.URL, nil))
+	if got := digest.FromBytes(img.Body.Bytes()); got.String() != card.Digest || int64(img.Body.Len()) != card.Size {
+		t.Errorf("image at %s = %s (%d bytes), card says %s (%d bytes)", card.URL, got, img.Body.Len(), card.Digest, card.Size)
+	}
+
+	var apiErr apiError
+	w = getJSON(t, h, "/api/v1/cards/kh", nil)
+	if w.Code != http.StatusNotFound || json.Unmarshal(w.Body.Bytes(), &apiErr) != nil || apiErr.Error == "" {
+		t.Errorf("GET /api/v1/cards/kh = %d %s, want JSON 404", w.Code, w.Body)
+	}
+}
+
+func TestAPIPassthrough(t *testing.T) {
+	h, ds := newTestDeckHandler(t, []string{"2c"})
+
+	w := getJSON(t, h, "/api/v1/manifest", nil)
+	if digest.FromBytes(w.Body.Bytes()) != ds.manifestDesc.Digest {
+		t.Error("manifest passthrough does not match the manifest digest")
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ocispec.MediaTypeImageManifest {
+		t.Errorf("manifest content-type = %q", ct)
+	}
+
+	w = getJSON(t, h, "/api/v1/config", nil)
+	if digest.FromBytes(w.Body.Bytes()) != ds.manifest.Config.Digest {
+		t.Error("config passthrough does not match the config digest")
+	}
+	if ct := w.Header().Get("Content-Type"); ct != configMediaType {
+		t.Errorf("config content-type = %q", ct)
+	}
+
+	var spec struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	getJSON(t, h, "/api/v1/openapi.json", &spec)
+	for _, path := range []string{"/api/v1/deck", "/api/v1/cards/{shorthand}", "/api/v1/manifest", "/api/v1/config", "/api/v1/shuffles", "/api/v1/shuffles/{id}/deal", "/api/v1/tables/{id}/events"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("OpenAPI description is missing %s", path)
+		}
+	}
+	if spec.OpenAPI == "" {
+		t.Error("OpenAPI description has no version")
+	}
+}
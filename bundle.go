@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// isArchive reports whether path is an existing regular file, which deck
+// sources treat as an oci-archive tarball.
+func isArchive(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// openArchive opens an oci-archive tarball read-only, without extracting it.
+func openArchive(ctx context.Context, path string) (*oci.ReadOnlyStore, error) {
+	store, err := oci.NewFromTar(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("opening OCI archive %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// archiveTag picks the tag to load from an archive: "latest" when present,
+// otherwise the only tag in it.
+func archiveTag(ctx context.Context, store *oci.ReadOnlyStore) (string, error) {
+	var tags []string
+	if err := store.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	for _, tag := range tags {
+		if tag == "latest" {
+			return tag, nil
+		}
+	}
+	if len(tags) == 1 {
+		return tags[0], nil
+	}
+	return "latest", nil
+}
+
+// writeArchive writes the OCI layout in dir to path as a tarball. The file is
+// replaced atomically so readers never see a partial archive.
+func writeArchive(dir, path string) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	tw := tar.NewWriter(tmp)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("writing archive %s: %w", path, err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("writing archive %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// extractArchive unpacks the tarball at path into dir.
+func extractArchive(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive %s: %w", path, err)
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("reading archive %s: invalid entry %q", path, hdr.Name)
+		}
+		target := filepath.Join(dir, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			out, err := os.Create(target)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// updateArchive extracts the archive at path into a scratch layout, runs fn
+// against it and writes the result back to path. With replace set, or when
+// path does not exist yet, fn starts from an empty layout.
+func updateArchive(ctx context.Context, path string, replace bool, fn func(store *oci.Store) error) error {
+	dir, err := os.MkdirTemp("", "card-oci-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if !replace && isArchive(path) {
+		if err := extractArchive(path, dir); err != nil {
+			return err
+		}
+	}
+	store, err := oci.NewWithContext(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("creating OCI layout: %w", err)
+	}
+	if err := fn(store); err != nil {
+		return err
+	}
+	return writeArchive(dir, path)
+}
+
+// bundleDecks writes each of refs, together with its referrers such as
+// signatures, into a new oci-archive tarball at output. Each deck keeps the
+// tag it was resolved with, or the tag named next to its digest; a deck named
+// by digest alone has no tag to keep and is refused.
+func bundleDecks(ctx context.Context, output string, refs []string, opts registryOptions) error {
+	if len(refs) == 0 {
+		return fmt.Errorf("no decks to bundle")
+	}
+	fmt.Printf("Bundling %d decks into %s ...\n", len(refs), output)
+	prog := newProgress(os.Stdout, opts.progress, "bundled")
+	err := updateArchive(ctx, output, true, func(store *oci.Store) error {
+		bundled := make(map[string]string)
+		for _, ref := range refs {
+			src, resolved, err := openDeck(ctx, ref, opts)
+			if err != nil {
+				return err
+			}
+			tag := keptTag(ref, resolved, opts)
+			if _, err := digest.Parse(tag); err == nil {
+				return fmt.Errorf("%s: bundling by digest needs a tag", ref)
+			}
+			graph, ok := src.(oras.ReadOnlyGraphTarget)
+			if !ok {
+				return fmt.Errorf("%s: listing referrers is not supported", ref)
+			}
+			if prev, ok := bundled[tag]; ok {
+				return fmt.Errorf("%s and %s both use tag %q", prev, ref, tag)
+			}
+			bundled[tag] = ref
+
+			var desc ocispec.Descriptor
+			err = opts.withRetry(ctx, "bundle", prog, func() error {
+				desc, err = oras.ExtendedCopy(ctx, newProgressGraphSource(graph, prog), resolved, store, tag, oras.DefaultExtendedCopyOptions)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("bundling %s: %w", ref, err)
+			}
+			fmt.Printf("  %s -> %s (%s)\n", ref, tag, desc.Digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	prog.finish()
+
+	fmt.Println("Done.")
+	return nil
+}
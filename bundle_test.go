@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry"
+)
+
+func TestBundleDecks(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	deck1 := writeDeckFile(t, []string{"2c", "ad"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deck1, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deck2 := writeDeckFile(t, []string{"kh"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v2", addr), deck2, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attach a signature-like referrer to v1.
+	repo, err := opts.newRepository(fmt.Sprintf("%s/deck:v1", addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := repo.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := oras.PackManifest(ctx, repo, oras.PackManifestVersion1_1, "application/vnd.example.signature", oras.PackManifestOptions{Subject: &subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(t.TempDir(), "decks.tar")
+	// v1 is pinned by digest and still bundled under its tag.
+	refs := []string{fmt.Sprintf("%s/deck:v1@%s", addr, subject.Digest), fmt.Sprintf("%s/deck:v2", addr)}
+	if err := bundleDecks(ctx, output, refs, opts); err != nil {
+		t.Fatalf("bundleDecks failed: %v", err)
+	}
+	untagged := []string{fmt.Sprintf("%s/deck@%s", addr, subject.Digest)}
+	if err := bundleDecks(ctx, filepath.Join(t.TempDir(), "digest.tar"), untagged, opts); err == nil || !strings.Contains(err.Error(), "needs a tag") {
+		t.Errorf("bundling by digest alone error = %v, want needs a tag", err)
+	}
+
+	store, err := openArchive(ctx, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := store.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatalf("v1 missing from bundle: %v", err)
+	}
+	if desc.Digest != subject.Digest {
+		t.Errorf("bundled v1 = %s, want %s", desc.Digest, subject.Digest)
+	}
+	referrers, err := registry.Referrers(ctx, store, desc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sig.Digest {
+		t.Errorf("bundled referrers = %v, want signature %s", referrers, sig.Digest)
+	}
+
+	ds, err := loadDeck(ctx, store, "v2")
+	if err != nil {
+		t.Fatalf("loadDeck from bundle failed: %v", err)
+	}
+	if len(ds.cards) != 1 || ds.cards[0] != "kh" {
+		t.Errorf("v2 cards = %v, want [kh]", ds.cards)
+	}
+
+	summaries, err := listDecks(ctx, output, opts)
+	if err != nil {
+		t.Fatalf("listDecks on bundle failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Errorf("got %d bundled decks, want 2", len(summaries))
+	}
+}
+
+func TestBundleDecksDuplicateTag(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	deck := writeDeckFile(t, []string{"2c"})
+	for _, repo := range []string{"a", "b"} {
+		if err := pushDeck(ctx, fmt.Sprintf("%s/%s:v1", addr, repo), deck, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(t.TempDir(), "decks.tar")
+	refs := []string{fmt.Sprintf("%s/a:v1", addr), fmt.Sprintf("%s/b:v1", addr)}
+	if err := bundleDecks(ctx, output, refs, opts); err == nil {
+		t.Fatal("expected error bundling two decks with the same tag")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("failed bundle left %s behind", output)
+	}
+}
+
+func TestSaveDeckLocalArchive(t *testing.T) {
+	ctx := context.Background()
+	output := filepath.Join(t.TempDir(), "deck.tar")
+
+	deckFile := writeDeckFile(t, []string{"2c", "ad", "kh"})
+	if err := saveDeckLocal(ctx, output, deckFile, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatalf("saveDeckLocal to archive failed: %v", err)
+	}
+	other := writeDeckFile(t, []string{"2c"})
+	if err := saveDeckLocal(ctx, output, other, "PNG-cards-1.3", "v2", pushOptions{}); err != nil {
+		t.Fatalf("adding to archive failed: %v", err)
+	}
+
+	// The archive is served directly, without unpacking.
+	src, tag, err := openDeck(ctx, output, registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "latest" {
+		t.Errorf("tag = %q, want latest", tag)
+	}
+	ds, err := loadDeck(ctx, src, tag)
+	if err != nil {
+		t.Fatalf("loadDeck from archive failed: %v", err)
+	}
+	if len(ds.cards) != 3 || len(ds.images) != 3 {
+		t.Errorf("got %d cards and %d images, want 3 and 3", len(ds.cards), len(ds.images))
+	}
+	if _, err := src.Resolve(ctx, "v2"); err != nil {
+		t.Errorf("v2 missing after second save: %v", err)
+	}
+}
+
+func TestArchiveTagSingle(t *testing.T) {
+	ctx := context.Background()
+	output := filepath.Join(t.TempDir(), "deck.tar")
+	deckFile := writeDeckFile(t, []string{"2c"})
+	if err := saveDeckLocal(ctx, output, deckFile, "PNG-cards-1.3", "1.0.0", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, tag, err := openDeck(ctx, output, registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "1.0.0" {
+		t.Errorf("tag = %q, want the archive's only tag 1.0.0", tag)
+	}
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "evil.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	body := "{}"
+	tw.WriteHeader(&tar.Header{Name: "../" + ocispec.ImageIndexFile, Mode: 0o644, Size: int64(len(body))})
+	tw.Write([]byte(body))
+	tw.Close()
+	f.Close()
+
+	err = extractArchive(path, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "invalid entry") {
+		t.Errorf("extractArchive error = %v, want invalid entry", err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+// defaultCacheSize bounds the layer cache of --serve, in MiB.
+const defaultCacheSize = 256
+
+// blobCache holds recently used blobs by digest, so a card image shared by
+// several decks, or asked for by many browsers at once, is fetched once. It
+// evicts the least recently used blobs beyond maxBytes; concurrent fetches of
+// the same blob share one request.
+type blobCache struct {
+	maxBytes int64 // 0 is unbounded
+
+	mu      sync.Mutex
+	entries map[digest.Digest]*list.Element // of *cacheEntry
+	lru     *list.List                      // most recently used first
+	size    int64
+	stats   cacheStats
+
+	inflight flightGroup[digest.Digest, []byte]
+}
+
+type cacheEntry struct {
+	digest digest.Digest
+	data   []byte
+}
+
+// cacheStats counts how a blobCache has been used; it is the /api/v1/cache
+// response. Coalesced fetches waited
+// for another request's fetch and are counted as hits too.
+type cacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Coalesced int64 `json:"coalesced"`
+	Evictions int64 `json:"evictions"`
+	Errors    int64 `json:"errors"` // failed fetches, not counting cancelled ones
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"maxBytes"`
+}
+
+func newBlobCache(maxBytes int64) *blobCache {
+	return &blobCache{
+		maxBytes: maxBytes,
+		entries:  make(map[digest.Digest]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// fetch returns the contents of desc, from the cache when present. A nil
+// cache always fetches.
+func (c *blobCache) fetch(ctx context.Context, src content.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	if c == nil {
+		return content.FetchAll(ctx, src, desc)
+	}
+	if data, ok := c.lookup(desc.Digest); ok {
+		return data, nil
+	}
+	joined := func() {
+		c.mu.Lock()
+		c.stats.Hits++
+		c.stats.Coalesced++
+		c.mu.Unlock()
+	}
+	return c.inflight.do(ctx, desc.Digest, joined, func() ([]byte, error) {
+		// The blob may have been cached since the lookup above.
+		if data, ok := c.lookup(desc.Digest); ok {
+			return data, nil
+		}
+		c.mu.Lock()
+		c.stats.Misses++
+		c.mu.Unlock()
+
+		data, err := content.FetchAll(ctx, src, desc)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err == nil {
+			c.add(desc.Digest, data)
+		} else if !errors.Is(err, context.Canceled) {
+			c.stats.Errors++
+		}
+		return data, err
+	})
+}
+
+// lookup returns the cached contents of d, counting a hit when present.
+func (c *blobCache) lookup(d digest.Digest) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[d]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	c.stats.Hits++
+	return e.Value.(*cacheEntry).data, true
+}
+
+// add caches data, evicting the least recently used blobs to make room. A
+// blob larger than the whole cache is not kept. c.mu must be held.
+func (c *blobCache) add(d digest.Digest, data []byte) {
+	size := int64(len(data))
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	c.entries[d] = c.lru.PushFront(&cacheEntry{digest: d, data: data})
+	c.size += size
+	for c.maxBytes > 0 && c.size > c.maxBytes {
+		e := c.lru.Back()
+		old := c.lru.Remove(e).(*cacheEntry)
+		delete(c.entries, old.digest)
+		c.size -= int64(len(old.data))
+		c.stats.Evictions++
+	}
+}
+
+// snapshot returns the current statistics of c; a nil cache has none.
+func (c *blobCache) snapshot() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries, s.Bytes, s.MaxBytes = len(c.entries), c.size, c.maxBytes
+	return s
+}
+
+// flight is a call in progress; done is closed once val or err is set.
+type flight[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// flightGroup makes concurrent calls for the same key share one call. The
+// zero value is ready to use.
+type flightGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*flight[V]
+}
+
+// do returns the result of fn, or, when a call for key is already in
+// progress, calls joined and waits for that call's result instead. The lock
+// is not held while fn runs.
+func (g *flightGroup[K, V]) do(ctx context.Context, key K, joined func(), fn func() (V, error)) (V, error) {
+	for {
+		g.mu.Lock()
+		if f, ok := g.calls[key]; ok {
+			g.mu.Unlock()
+			if joined != nil {
+				joined()
+			}
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				var zero V
+				return zero, ctx.Err()
+			}
+			// A call abandoned by the request that started it is retried
+			// by the requests still waiting for it.
+			if errors.Is(f.err, context.Canceled) && ctx.Err() == nil {
+				continue
+			}
+			return f.val, f.err
+		}
+		if g.calls == nil {
+			g.calls = make(map[K]*flight[V])
+		}
+		f := &flight[V]{done: make(chan struct{})}
+		g.calls[key] = f
+		g.mu.Unlock()
+
+		f.val, f.err = fn()
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(f.done)
+		return f.val, f.err
+	}
+}
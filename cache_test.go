@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// countingFetcher serves blobs from memory, counting fetches. With gate set,
+// each fetch waits for it to be closed or for the context to end.
+type countingFetcher struct {
+	blobs   map[digest.Digest][]byte
+	gate    chan struct{}
+	fetches atomic.Int64
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	f.fetches.Add(1)
+	if f.gate != nil {
+		select {
+		case <-f.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return io.NopCloser(bytes.NewReader(f.blobs[desc.Digest])), nil
+}
+
+func testBlobs(sizes ...int) (*countingFetcher, []ocispec.Descriptor) {
+	f := &countingFetcher{blobs: make(map[digest.Digest][]byte)}
+	var descs []ocispec.Descriptor
+	for i, n := range sizes {
+		data := bytes.Repeat([]byte{byte('a' + i)}, n)
+		d := digest.FromBytes(data)
+		f.blobs[d] = data
+		descs = append(descs, ocispec.Descriptor{MediaType: "image/png", Digest: d, Size: int64(n)})
+	}
+	return f, descs
+}
+
+func TestBlobCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	f, descs := testBlobs(40, 40, 40, 200)
+	c := newBlobCache(100)
+	fetch := func(i int) {
+		t.Helper()
+		data, err := c.fetch(ctx, f, descs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, f.blobs[descs[i].Digest]) {
+			t.Fatalf("blob %d has the wrong contents", i)
+		}
+	}
+
+	fetch(0)
+	fetch(1)
+	fetch(0) // 1 is now the least recently used
+	fetch(2) // evicts 1
+	fetch(0)
+	fetch(1)
+	fetch(3) // larger than the cache: served but not kept
+	fetch(3)
+
+	s := c.snapshot()
+	want := cacheStats{Hits: 2, Misses: 6, Evictions: 2, Entries: 2, Bytes: 80, MaxBytes: 100}
+	if s != want {
+		t.Errorf("stats = %+v, want %+v", s, want)
+	}
+	if n := f.fetches.Load(); n != 6 {
+		t.Errorf("fetched %d times, want 6", n)
+	}
+}
+
+func TestBlobCacheCoalescing(t *testing.T) {
+	ctx := context.Background()
+	f, descs := testBlobs(10)
+	f.gate = make(chan struct{})
+	c := newBlobCache(0)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.fetch(ctx, f, descs[0]); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for c.snapshot().Coalesced < 7 {
+		runtime.Gosched()
+	}
+	close(f.gate)
+	wg.Wait()
+
+	if n := f.fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+	if s := c.snapshot(); s.Misses != 1 || s.Hits != 7 {
+		t.Errorf("stats = %+v, want 1 miss and 7 hits", s)
+	}
+}
+
+func TestBlobCacheAbandonedFetch(t *testing.T) {
+	f, descs := testBlobs(10)
+	f.gate = make(chan struct{})
+	c := newBlobCache(0)
+
+	first, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.fetch(first, f, descs[0])
+		errc <- err
+	}()
+	for f.fetches.Load() == 0 {
+		runtime.Gosched()
+	}
+	done := make(chan []byte)
+	go func() {
+		data, err := c.fetch(context.Background(), f, descs[0])
+		if err != nil {
+			t.Error(err)
+		}
+		done <- data
+	}()
+	for c.snapshot().Coalesced == 0 {
+		runtime.Gosched()
+	}
+
+	// The request that started the fetch goes away; the one waiting on it
+	// fetches the blob itself.
+	cancel()
+	if err := <-errc; err == nil {
+		t.Error("expected the cancelled fetch to fail")
+	}
+	close(f.gate)
+	if data := <-done; len(data) != 10 {
+		t.Errorf("got %d bytes, want 10", len(data))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// keptTag returns the tag a copy of source carries, given the tag or digest
+// ref openDeck resolved it to. Copying "repo:tag@digest" or a locked
+// reference loads by digest but keeps the tag name.
+func keptTag(source, ref string, opts registryOptions) string {
+	if locked, ok := opts.lock.lookup(source); ok && locked.Tag != "" {
+		return locked.Tag
+	}
+	if srcRef, err := parseDeckRef(source); err == nil && srcRef.Tag != "" {
+		return srcRef.Tag
+	}
+	return ref
+}
+
+// copyDeck copies the deck at source to dest, both parsed with parseDeckRef.
+// A destination without a tag keeps the source tag. When both are
+// repositories on the same registry, shared blobs are mounted from the source
+// repository instead of being uploaded again; push.mountFrom adds further
+// candidates.
+func copyDeck(ctx context.Context, source, dest string, opts registryOptions, push pushOptions) error {
+	src, tag, err := openDeck(ctx, source, opts)
+	if err != nil {
+		return err
+	}
+	dstRef, err := parseDeckRef(dest)
+	if err != nil {
+		return fmt.Errorf("invalid destination reference: %w", err)
+	}
+	if dstRef.Range != "" {
+		return fmt.Errorf("invalid destination reference %q: copying needs a tag, not a version range", dest)
+	}
+	dstTag := keptTag(source, tag, opts)
+	switch {
+	case dstRef.Tag != "":
+		dstTag = dstRef.Tag
+	case dstRef.Digest != "":
+		dstTag = dstRef.Digest.String()
+	}
+	if _, err := digest.Parse(dstTag); err == nil && dstRef.Transport != transportRegistry {
+		return fmt.Errorf("copying by digest into %s needs a destination tag", dstRef.Location)
+	}
+
+	var dstRepo *remote.Repository
+	var mounts []string
+	if dstRef.Transport == transportRegistry {
+		dstRepo, err = opts.newRepository(dstRef.remote())
+		if err != nil {
+			return fmt.Errorf("invalid destination reference: %w", err)
+		}
+		if srcRepo, ok := src.(*remote.Repository); ok &&
+			srcRepo.Reference.Registry == dstRepo.Reference.Registry &&
+			srcRepo.Reference.Repository != dstRepo.Reference.Repository {
+			mounts = append(mounts, srcRepo.Reference.Repository)
+		}
+		extra, err := mountRepositories(dstRepo.Reference, push.mountFrom)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, extra...)
+	} else if len(push.mountFrom) > 0 {
+		return fmt.Errorf("--mount-from requires a registry destination")
+	}
+
+	fmt.Printf("Copying %s to %s ...\n", source, dest)
+	prog := newProgress(os.Stdout, opts.progress, "copied")
+	copyOpts := oras.CopyOptions{}
+	copyOpts.OnCopySkipped = func(_ context.Context, desc ocispec.Descriptor) error {
+		prog.skip(desc)
+		return nil
+	}
+	enableMounts(&copyOpts, mounts, prog)
+	copyTo := func(dst oras.Target) error {
+		return opts.withRetry(ctx, "copy", prog, func() error {
+			_, err := oras.Copy(ctx, newProgressSource(src, prog), tag, dst, dstTag, copyOpts)
+			return err
+		})
+	}
+
+	switch dstRef.Transport {
+	case transportOCI:
+		var store *oci.Store
+		if store, err = oci.NewWithContext(ctx, dstRef.Location); err != nil {
+			return fmt.Errorf("opening OCI layout %s: %w", dstRef.Location, err)
+		}
+		err = copyTo(store)
+	case transportArchive:
+		err = updateArchive(ctx, dstRef.Location, false, func(store *oci.Store) error {
+			return copyTo(store)
+		})
+	default:
+		err = copyTo(dstRepo)
+	}
+	if err != nil {
+		return fmt.Errorf("copying deck: %w", err)
+	}
+	prog.finish()
+
+	fmt.Println("Done.")
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/olareg/olareg"
+	"github.com/olareg/olareg/config"
+	"oras.land/oras-go/v2/registry"
+)
+
+// uploadCounts tracks blob mount requests and full blob uploads seen by a
+// registry started with setupCountingRegistry.
+type uploadCounts struct {
+	mounts  atomic.Int32
+	uploads atomic.Int32
+}
+
+// setupCountingRegistry starts an in-memory olareg registry that counts
+// cross-repository mount requests and completed blob uploads.
+func setupCountingRegistry(t *testing.T) (string, *uploadCounts) {
+	t.Helper()
+	regHandler := olareg.New(config.Config{
+		Storage: config.ConfigStorage{
+			StoreType: config.StoreMem,
+		},
+	})
+	counts := &uploadCounts{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Query().Get("mount") != "":
+			counts.mounts.Add(1)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/blobs/uploads/"):
+			counts.uploads.Add(1)
+		}
+		regHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+		regHandler.Close()
+	})
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host, counts
+}
+
+func TestPushDeckMountFrom(t *testing.T) {
+	addr, counts := setupCountingRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	base := writeDeckFile(t, []string{"2c", "ad"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/team-a/deck:v1", addr), base, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	uploaded := counts.uploads.Load()
+
+	// Both cards exist in team-a/deck; only kh and the config are new.
+	deck := writeDeckFile(t, []string{"2c", "ad", "kh"})
+	push := pushOptions{mountFrom: []string{"team-a/deck"}}
+	if err := pushDeck(ctx, fmt.Sprintf("%s/team-b/deck:v1", addr), deck, "PNG-cards-1.3", opts, push); err != nil {
+		t.Fatalf("pushDeck with --mount-from failed: %v", err)
+	}
+	if got := counts.mounts.Load(); got < 2 {
+		t.Errorf("mount requests = %d, want at least 2", got)
+	}
+	// kh and the new config are uploaded; 2c and ad are mounted.
+	if got := counts.uploads.Load() - uploaded; got != 2 {
+		t.Errorf("blob uploads = %d, want 2", got)
+	}
+
+	src, tag, err := openDeck(ctx, fmt.Sprintf("%s/team-b/deck:v1", addr), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := loadDeck(ctx, src, tag)
+	if err != nil {
+		t.Fatalf("loadDeck after mounted push failed: %v", err)
+	}
+	if len(ds.cards) != 3 {
+		t.Errorf("got %d cards, want 3", len(ds.cards))
+	}
+}
+
+func TestCopyDeckAutoMount(t *testing.T) {
+	addr, counts := setupCountingRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	deck := writeDeckFile(t, []string{"2c", "ad", "kh"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/decks/poker:v1", addr), deck, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	uploaded := counts.uploads.Load()
+
+	// No tag on the destination keeps the source tag.
+	if err := copyDeck(ctx, fmt.Sprintf("%s/decks/poker:v1", addr), fmt.Sprintf("%s/archive/poker", addr), opts, pushOptions{}); err != nil {
+		t.Fatalf("copyDeck failed: %v", err)
+	}
+	if got := counts.uploads.Load() - uploaded; got != 0 {
+		t.Errorf("blob uploads = %d, want 0 (all blobs mounted)", got)
+	}
+	// Three card images plus the config.
+	if got := counts.mounts.Load(); got != 4 {
+		t.Errorf("mount requests = %d, want 4", got)
+	}
+
+	a, err := loadSnapshot(ctx, fmt.Sprintf("%s/decks/poker:v1", addr), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadSnapshot(ctx, fmt.Sprintf("%s/archive/poker:v1", addr), opts)
+	if err != nil {
+		t.Fatalf("copied deck not found: %v", err)
+	}
+	if a.Desc.Digest != b.Desc.Digest {
+		t.Errorf("copied manifest %s, want %s", b.Desc.Digest, a.Desc.Digest)
+	}
+}
+
+func TestCopyDeckToLayout(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	deck := writeDeckFile(t, []string{"2c", "ad"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deck, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	outputDir := filepath.Join(t.TempDir(), "layout")
+	if err := saveDeckLocal(ctx, outputDir, deck, "PNG-cards-1.3", "other", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), outputDir, opts, pushOptions{}); err != nil {
+		t.Fatalf("copyDeck to layout failed: %v", err)
+	}
+	summaries, err := listDecks(ctx, outputDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[1].Tag != "v1" {
+		t.Errorf("layout tags = %+v, want other and v1", summaries)
+	}
+
+	push := pushOptions{mountFrom: []string{"deck"}}
+	if err := copyDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), outputDir, opts, push); err == nil {
+		t.Error("expected --mount-from to be rejected for a layout destination")
+	}
+}
+
+func TestMountRepository(t *testing.T) {
+	target := registry.Reference{Registry: "localhost:5000", Repository: "team-b/deck"}
+	tests := []struct {
+		from    string
+		want    string
+		wantErr bool
+	}{
+		{"team-a/deck", "team-a/deck", false},
+		{"deck", "deck", false},
+		{"localhost:5000/team-a/deck", "team-a/deck", false},
+		{"localhost:5000/team-a/deck:v1", "team-a/deck", false},
+		{"ghcr.io/team-a/deck", "", true},
+		{"Team-A/Deck", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			got, err := mountRepository(target, tt.from)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("mountRepository(%q) = %q, want error", tt.from, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mountRepository(%q) failed: %v", tt.from, err)
+			}
+			if got != tt.want {
+				t.Errorf("mountRepository(%q) = %q, want %q", tt.from, got, tt.want)
+			}
+		})
+	}
+}
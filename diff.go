@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// deckSnapshot is the manifest and card list of one deck version.
+type deckSnapshot struct {
+	Ref      string
+	Desc     ocispec.Descriptor
+	Manifest ocispec.Manifest
+	Cards    []string
+}
+
+// loadSnapshot fetches the manifest and config for source without
+// downloading any card images.
+func loadSnapshot(ctx context.Context, source string, opts registryOptions) (deckSnapshot, error) {
+	src, tag, err := openDeck(ctx, source, opts)
+	if err != nil {
+		return deckSnapshot{}, err
+	}
+	desc, manifest, err := fetchManifest(ctx, src, tag)
+	if err != nil {
+		return deckSnapshot{}, err
+	}
+	cards, err := fetchCards(ctx, src, manifest)
+	if err != nil {
+		return deckSnapshot{}, err
+	}
+	return deckSnapshot{Ref: source, Desc: desc, Manifest: manifest, Cards: cards}, nil
+}
+
+// cardMove records a card whose position changed relative to the others.
+type cardMove struct {
+	Card string `json:"card"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// imageChange records a card whose image layer has different content.
+type imageChange struct {
+	Card string `json:"card"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// annotationChange records a manifest annotation that was added, removed or
+// changed. From or To is empty when the key is missing on that side.
+type annotationChange struct {
+	Key  string `json:"key"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// blobDiff counts config and layer blobs present in both decks, only in the
+// new one, and only in the old one.
+type blobDiff struct {
+	Shared       int   `json:"shared"`
+	SharedBytes  int64 `json:"sharedBytes"`
+	New          int   `json:"new"`
+	NewBytes     int64 `json:"newBytes"`
+	Dropped      int   `json:"dropped"`
+	DroppedBytes int64 `json:"droppedBytes"`
+}
+
+// deckDiff describes what changed between two deck versions.
+type deckDiff struct {
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	FromDigest    string             `json:"fromDigest"`
+	ToDigest      string             `json:"toDigest"`
+	FromCards     int                `json:"fromCards"`
+	ToCards       int                `json:"toCards"`
+	Added         []string           `json:"added"`
+	Removed       []string           `json:"removed"`
+	Moved         []cardMove         `json:"moved"`
+	ChangedImages []imageChange      `json:"changedImages"`
+	Blobs         blobDiff           `json:"blobs"`
+	Annotations   []annotationChange `json:"annotations"`
+}
+
+// Identical reports whether the two decks have the same manifest.
+func (d deckDiff) Identical() bool {
+	return d.FromDigest == d.ToDigest
+}
+
+// diffDecks loads both references and compares them.
+func diffDecks(ctx context.Context, refA, refB string, opts registryOptions) (deckDiff, error) {
+	a, err := loadSnapshot(ctx, refA, opts)
+	if err != nil {
+		return deckDiff{}, fmt.Errorf("%s: %w", refA, err)
+	}
+	b, err := loadSnapshot(ctx, refB, opts)
+	if err != nil {
+		return deckDiff{}, fmt.Errorf("%s: %w", refB, err)
+	}
+	return compareDecks(a, b), nil
+}
+
+// compareDecks reports card, image, blob and annotation differences from a to b.
+func compareDecks(a, b deckSnapshot) deckDiff {
+	d := deckDiff{
+		From:          a.Ref,
+		To:            b.Ref,
+		FromDigest:    a.Desc.Digest.String(),
+		ToDigest:      b.Desc.Digest.String(),
+		FromCards:     len(a.Cards),
+		ToCards:       len(b.Cards),
+		Added:         []string{},
+		Removed:       []string{},
+		Moved:         []cardMove{},
+		ChangedImages: []imageChange{},
+		Annotations:   []annotationChange{},
+	}
+
+	// Cards can repeat, so added and removed compare counts per card.
+	countA, countB := countCards(a.Cards), countCards(b.Cards)
+	for _, card := range sortedKeys(countA, countB) {
+		for i := countB[card]; i < countA[card]; i++ {
+			d.Removed = append(d.Removed, card)
+		}
+		for i := countA[card]; i < countB[card]; i++ {
+			d.Added = append(d.Added, card)
+		}
+	}
+
+	d.Moved = movedCards(a.Cards, b.Cards)
+
+	layersA, layersB := cardLayers(a.Manifest), cardLayers(b.Manifest)
+	for _, card := range sortedKeys(layersA, layersB) {
+		la, okA := layersA[card]
+		lb, okB := layersB[card]
+		if okA && okB && la.Digest != lb.Digest {
+			d.ChangedImages = append(d.ChangedImages, imageChange{Card: card, From: la.Digest.String(), To: lb.Digest.String()})
+		}
+	}
+
+	blobsA, blobsB := manifestBlobs(a.Manifest), manifestBlobs(b.Manifest)
+	for dgst, size := range blobsB {
+		if _, ok := blobsA[dgst]; ok {
+			d.Blobs.Shared++
+			d.Blobs.SharedBytes += size
+		} else {
+			d.Blobs.New++
+			d.Blobs.NewBytes += size
+		}
+	}
+	for dgst, size := range blobsA {
+		if _, ok := blobsB[dgst]; !ok {
+			d.Blobs.Dropped++
+			d.Blobs.DroppedBytes += size
+		}
+	}
+
+	for _, key := range sortedKeys(a.Manifest.Annotations, b.Manifest.Annotations) {
+		va, vb := a.Manifest.Annotations[key], b.Manifest.Annotations[key]
+		if va != vb {
+			d.Annotations = append(d.Annotations, annotationChange{Key: key, From: va, To: vb})
+		}
+	}
+	return d
+}
+
+func countCards(cards []string) map[string]int {
+	counts := make(map[string]int)
+	for _, c := range cards {
+		counts[c]++
+	}
+	return counts
+}
+
+// sortedKeys returns the union of the keys of a and b in sorted order.
+func sortedKeys[V any](a, b map[string]V) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]V{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// firstIndex maps each card to the position of its first appearance.
+func firstIndex(cards []string) map[string]int {
+	idx := make(map[string]int)
+	for i, c := range cards {
+		if _, ok := idx[c]; !ok {
+			idx[c] = i
+		}
+	}
+	return idx
+}
+
+// movedCards returns the cards present in both decks that fall outside the
+// longest common subsequence of their shared ordering, i.e. the smallest set
+// of cards whose moves explain the reordering.
+func movedCards(a, b []string) []cardMove {
+	idxA, idxB := firstIndex(a), firstIndex(b)
+	var seqA, seqB []string
+	for i, c := range a {
+		if _, ok := idxB[c]; ok && idxA[c] == i {
+			seqA = append(seqA, c)
+		}
+	}
+	for i, c := range b {
+		if _, ok := idxA[c]; ok && idxB[c] == i {
+			seqB = append(seqB, c)
+		}
+	}
+
+	// lcs[i][j] is the LCS length of seqA[i:] and seqB[j:].
+	lcs := make([][]int, len(seqA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(seqB)+1)
+	}
+	for i := len(seqA) - 1; i >= 0; i-- {
+		for j := len(seqB) - 1; j >= 0; j-- {
+			if seqA[i] == seqB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	inOrder := make(map[string]bool)
+	for i, j := 0, 0; i < len(seqA) && j < len(seqB); {
+		switch {
+		case seqA[i] == seqB[j]:
+			inOrder[seqA[i]] = true
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	moved := []cardMove{}
+	for _, c := range seqB {
+		if !inOrder[c] {
+			moved = append(moved, cardMove{Card: c, From: idxA[c], To: idxB[c]})
+		}
+	}
+	return moved
+}
+
+// cardLayers maps card shorthands to their image layers.
+func cardLayers(manifest ocispec.Manifest) map[string]ocispec.Descriptor {
+	layers := make(map[string]ocispec.Descriptor)
+	for _, layer := range manifest.Layers {
+		if card := layer.Annotations[cardAnnotation]; card != "" {
+			layers[card] = layer
+		}
+	}
+	return layers
+}
+
+// manifestBlobs maps the digests of the config and layers to their sizes.
+func manifestBlobs(manifest ocispec.Manifest) map[string]int64 {
+	blobs := map[string]int64{manifest.Config.Digest.String(): manifest.Config.Size}
+	for _, layer := range manifest.Layers {
+		blobs[layer.Digest.String()] = layer.Size
+	}
+	return blobs
+}
+
+// printDeckDiff writes d to w as text or JSON.
+func printDeckDiff(w io.Writer, d deckDiff, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	case "text", "":
+	default:
+		return fmt.Errorf("unknown output format %q (want text or json)", format)
+	}
+
+	fmt.Fprintf(w, "--- %s (%s)\n", d.From, d.FromDigest)
+	fmt.Fprintf(w, "+++ %s (%s)\n", d.To, d.ToDigest)
+	if d.Identical() {
+		fmt.Fprintln(w, "Decks are identical.")
+		return nil
+	}
+
+	fmt.Fprintf(w, "Cards: %d -> %d\n", d.FromCards, d.ToCards)
+	for _, c := range d.Added {
+		fmt.Fprintf(w, "  + %s\n", c)
+	}
+	for _, c := range d.Removed {
+		fmt.Fprintf(w, "  - %s\n", c)
+	}
+	for _, m := range d.Moved {
+		fmt.Fprintf(w, "  ~ %s moved from position %d to %d\n", m.Card, m.From, m.To)
+	}
+	if len(d.ChangedImages) > 0 {
+		fmt.Fprintln(w, "Images changed:")
+		for _, c := range d.ChangedImages {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", c.Card, c.From, c.To)
+		}
+	}
+	fmt.Fprintf(w, "Blobs: %d shared (%s), %d new (%s), %d dropped (%s)\n",
+		d.Blobs.Shared, formatBytes(d.Blobs.SharedBytes),
+		d.Blobs.New, formatBytes(d.Blobs.NewBytes),
+		d.Blobs.Dropped, formatBytes(d.Blobs.DroppedBytes))
+	if len(d.Annotations) > 0 {
+		fmt.Fprintln(w, "Annotations:")
+		for _, a := range d.Annotations {
+			switch {
+			case a.From == "":
+				fmt.Fprintf(w, "  + %s: %s\n", a.Key, a.To)
+			case a.To == "":
+				fmt.Fprintf(w, "  - %s: %s\n", a.Key, a.From)
+			default:
+				fmt.Fprintf(w, "  ~ %s: %s -> %s\n", a.Key, a.From, a.To)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// copyImages copies the named card images into a fresh directory.
+func copyImages(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("PNG-cards-1.3", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMovedCards(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{"same order", []string{"2c", "ad", "kh"}, []string{"2c", "ad", "kh"}, nil},
+		{"one moved to front", []string{"2c", "ad", "kh"}, []string{"kh", "2c", "ad"}, []string{"kh"}},
+		{"added and removed only", []string{"2c", "ad"}, []string{"2c", "kh", "ad"}, nil},
+		{"swap", []string{"2c", "ad"}, []string{"ad", "2c"}, []string{"2c"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, m := range movedCards(tc.a, tc.b) {
+				got = append(got, m.Card)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("movedCards = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiffDecks(t *testing.T) {
+	ctx := context.Background()
+
+	dirA := filepath.Join(t.TempDir(), "a")
+	deckA := writeDeckFile(t, []string{"2c", "ad", "3h", "kh", "qh", "qh"})
+	if err := saveDeckLocal(ctx, dirA, deckA, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deck B drops ad and one qh, adds 3s, moves kh to the front and uses a
+	// different image for 2c.
+	images := copyImages(t, "2_of_clubs.png", "3_of_hearts.png", "king_of_hearts.png", "queen_of_hearts.png", "3_of_spades.png")
+	f, err := os.OpenFile(filepath.Join(images, "2_of_clubs.png"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("retouched"))
+	f.Close()
+	dirB := filepath.Join(t.TempDir(), "b")
+	deckB := writeDeckFile(t, []string{"kh", "2c", "3h", "qh", "3s"})
+	if err := saveDeckLocal(ctx, dirB, deckB, images, "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := diffDecks(ctx, dirA, dirB, registryOptions{})
+	if err != nil {
+		t.Fatalf("diffDecks failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(d.Added, []string{"3s"}) {
+		t.Errorf("added = %v, want [3s]", d.Added)
+	}
+	if !reflect.DeepEqual(d.Removed, []string{"ad", "qh"}) {
+		t.Errorf("removed = %v, want [ad qh]", d.Removed)
+	}
+	if len(d.Moved) != 1 || d.Moved[0] != (cardMove{Card: "kh", From: 3, To: 0}) {
+		t.Errorf("moved = %+v, want kh 3 -> 0", d.Moved)
+	}
+	if len(d.ChangedImages) != 1 || d.ChangedImages[0].Card != "2c" {
+		t.Errorf("changed images = %+v, want 2c", d.ChangedImages)
+	}
+	// 3h, kh and qh are shared; 2c, 3s and the config are new; 2c, ad and
+	// the old config are dropped.
+	if d.Blobs.Shared != 3 || d.Blobs.New != 3 || d.Blobs.Dropped != 3 {
+		t.Errorf("blobs = %+v", d.Blobs)
+	}
+	if d.Blobs.SharedBytes == 0 || d.Blobs.NewBytes == 0 || d.Blobs.DroppedBytes == 0 {
+		t.Errorf("blob byte counts missing: %+v", d.Blobs)
+	}
+
+	var text bytes.Buffer
+	if err := printDeckDiff(&text, d, "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Cards: 6 -> 5", "  + 3s", "  - ad", "  ~ kh moved from position 3 to 0", "Images changed:", "  2c: ", "Blobs: 3 shared"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text output missing %q:\n%s", want, text.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printDeckDiff(&out, d, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded deckDiff
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, d) {
+		t.Errorf("JSON round trip = %+v, want %+v", decoded, d)
+	}
+}
+
+func TestDiffDecksIdentical(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "deck")
+	deckFile := writeDeckFile(t, []string{"2c", "ad"})
+	if err := saveDeckLocal(ctx, dir, deckFile, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := diffDecks(ctx, dir, dir, registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Identical() {
+		t.Fatal("expected identical decks")
+	}
+	if len(d.Added)+len(d.Removed)+len(d.Moved)+len(d.ChangedImages)+len(d.Annotations) != 0 {
+		t.Errorf("identical decks reported changes: %+v", d)
+	}
+	var text bytes.Buffer
+	if err := printDeckDiff(&text, d, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "Decks are identical.") {
+		t.Errorf("unexpected output:\n%s", text.String())
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+)
+
+var errContentUnknown = errors.New("not part of the deck")
+
+// registryAPI answers read-only OCI distribution-spec requests under /v2/,
+// exposing each served deck as a repository named after it, so clients such
+// as oras can pull the decks from the viewer. Content comes from the loaded
+// deck; referrers and blobs it does not hold are fetched from its source on
+// first use.
+type registryAPI struct {
+	decks map[string]*deckHandler
+	mux   *http.ServeMux
+}
+
+func newRegistryAPI(sources []deckSource, decks []*deckHandler) *registryAPI {
+	api := &registryAPI{decks: make(map[string]*deckHandler), mux: http.NewServeMux()}
+	for i, src := range sources {
+		api.decks[src.Name] = decks[i]
+	}
+	api.mux.HandleFunc("GET /v2/{$}", handleRegistryBase)
+	api.mux.HandleFunc("GET /v2/{name}/manifests/{reference}", api.withDeck(handleRegistryManifest))
+	api.mux.HandleFunc("GET /v2/{name}/blobs/{digest}", api.withDeck(handleRegistryBlob))
+	api.mux.HandleFunc("GET /v2/{name}/tags/list", api.withDeck(handleRegistryTags))
+	api.mux.HandleFunc("GET /v2/{name}/referrers/{digest}", api.withDeck(handleRegistryReferrers))
+	api.mux.HandleFunc("/v2/", handleRegistryUnsupported)
+	return api
+}
+
+func (api *registryAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	api.mux.ServeHTTP(w, r)
+	noteRoute(r)
+}
+
+// withDeck adapts a handler to the deck named by the request path.
+func (api *registryAPI) withDeck(fn func(*deckHandler, *deckServer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := api.decks[r.PathValue("name")]
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+			return
+		}
+		ds := h.deck.Load()
+		if ds == nil {
+			http.Error(w, "deck is still loading", http.StatusServiceUnavailable)
+			return
+		}
+		fn(h, ds, w, r)
+	}
+}
+
+type registryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeRegistryError writes an error in the distribution-spec format.
+func writeRegistryError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, struct {
+		Errors []registryError `json:"errors"`
+	}{[]registryError{{Code: code, Message: msg}}})
+}
+
+func handleRegistryBase(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// handleRegistryUnsupported refuses pushes and deletes and answers unknown
+// paths.
+func handleRegistryUnsupported(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "the registry is read-only")
+		return
+	}
+	writeRegistryError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+}
+
+func handleRegistryManifest(h *deckHandler, ds *deckServer, w http.ResponseWriter, r *http.Request) {
+	ref := r.PathValue("reference")
+	if ds.tag != "" && ref == ds.tag {
+		ref = ds.manifestDesc.Digest.String()
+	}
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown to registry")
+		return
+	}
+	desc, data, err := ds.registryManifest(r.Context(), h.cache, dgst)
+	if errors.Is(err, errContentUnknown) {
+		writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown to registry")
+		return
+	}
+	if err != nil {
+		writeRegistryError(w, http.StatusBadGateway, "UNKNOWN", err.Error())
+		return
+	}
+	serveRegistryContent(w, r, desc, data)
+}
+
+func handleRegistryBlob(h *deckHandler, ds *deckServer, w http.ResponseWriter, r *http.Request) {
+	dgst, err := digest.Parse(r.PathValue("digest"))
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+	desc, data, err := ds.registryBlob(r.Context(), h.cache, dgst)
+	if errors.Is(err, errContentUnknown) {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	if err != nil {
+		writeRegistryError(w, http.StatusBadGateway, "UNKNOWN", err.Error())
+		return
+	}
+	serveRegistryContent(w, r, desc, data)
+}
+
+// serveRegistryContent serves data as the content of desc, answering HEAD
+// and range requests.
+func serveRegistryContent(w http.ResponseWriter, r *http.Request, desc ocispec.Descriptor, data []byte) {
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// handleRegistryTags lists the tag the deck was loaded with; a deck served by
+// digest has none.
+func handleRegistryTags(h *deckHandler, ds *deckServer, w http.ResponseWriter, r *http.Request) {
+	tags := []string{}
+	if ds.tag != "" {
+		tags = append(tags, ds.tag)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{r.PathValue("name"), tags})
+}
+
+func handleRegistryReferrers(h *deckHandler, ds *deckServer, w http.ResponseWriter, r *http.Request) {
+	dgst, err := digest.Parse(r.PathValue("digest"))
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{},
+	}
+	if dgst == ds.manifestDesc.Digest {
+		g, err := ds.referrerGraph(r.Context(), h.cache)
+		if err != nil {
+			writeRegistryError(w, http.StatusBadGateway, "UNKNOWN", err.Error())
+			return
+		}
+		artifactType := r.URL.Query().Get("artifactType")
+		for _, desc := range g.referrers {
+			if artifactType == "" || desc.ArtifactType == artifactType {
+				index.Manifests = append(index.Manifests, desc)
+			}
+		}
+		if artifactType != "" {
+			w.Header().Set("OCI-Filters-Applied", "artifactType")
+		}
+	}
+	w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+	json.NewEncoder(w).Encode(index)
+}
+
+// referrerGraphTTL is how long a listing of referrers is served before the
+// source is asked again, so signatures pushed after the deck show up.
+var referrerGraphTTL = time.Minute
+
+// deckGraph indexes the manifests referring to a deck, such as signatures,
+// and their blobs.
+type deckGraph struct {
+	referrers []ocispec.Descriptor
+	blobs     map[digest.Digest]ocispec.Descriptor
+	listed    time.Time
+}
+
+// referrerGraph returns the referrers of ds, listing them from its source
+// the first time they are asked for and again once the listing is older than
+// referrerGraphTTL. Concurrent requests share one listing.
+func (ds *deckServer) referrerGraph(ctx context.Context, cache *blobCache) (*deckGraph, error) {
+	if g := ds.freshGraph(); g != nil {
+		return g, nil
+	}
+	return ds.graphLoads.do(ctx, struct{}{}, nil, func() (*deckGraph, error) {
+		// Another request may have listed them since the check above.
+		if g := ds.freshGraph(); g != nil {
+			return g, nil
+		}
+		g, err := ds.listReferrers(ctx, cache)
+		if err != nil {
+			return nil, err
+		}
+		ds.graphMu.Lock()
+		ds.graph = g
+		ds.graphMu.Unlock()
+		return g, nil
+	})
+}
+
+// freshGraph returns the last listing of referrers if it is not stale.
+func (ds *deckServer) freshGraph() *deckGraph {
+	ds.graphMu.Lock()
+	defer ds.graphMu.Unlock()
+	if g := ds.graph; g != nil && time.Since(g.listed) < referrerGraphTTL {
+		return g
+	}
+	return nil
+}
+
+// listReferrers lists the referrers of ds from its source.
+func (ds *deckServer) listReferrers(ctx context.Context, cache *blobCache) (*deckGraph, error) {
+	g := &deckGraph{blobs: make(map[digest.Digest]ocispec.Descriptor), listed: time.Now()}
+	if storage, ok := ds.src.(content.ReadOnlyGraphStorage); ok {
+		referrers, err := registry.Referrers(ctx, storage, ds.manifestDesc, "")
+		if err != nil {
+			return nil, fmt.Errorf("listing referrers: %w", err)
+		}
+		for _, desc := range referrers {
+			data, err := cache.fetch(ctx, ds.src, desc)
+			if err != nil {
+				return nil, fmt.Errorf("fetching referrer %s: %w", desc.Digest, err)
+			}
+			var manifest ocispec.Manifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return nil, fmt.Errorf("unmarshaling referrer %s: %w", desc.Digest, err)
+			}
+			for _, blob := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+				if blob.Digest != "" {
+					g.blobs[blob.Digest] = blob
+				}
+			}
+			g.referrers = append(g.referrers, desc)
+		}
+	}
+	return g, nil
+}
+
+// registryManifest returns the deck manifest or one of its referrers.
+func (ds *deckServer) registryManifest(ctx context.Context, cache *blobCache, dgst digest.Digest) (ocispec.Descriptor, []byte, error) {
+	if dgst == ds.manifestDesc.Digest {
+		return ds.manifestDesc, ds.manifestBytes, nil
+	}
+	g, err := ds.referrerGraph(ctx, cache)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	for _, desc := range g.referrers {
+		if desc.Digest == dgst {
+			return ds.fetchFromSource(ctx, cache, desc)
+		}
+	}
+	return ocispec.Descriptor{}, nil, errContentUnknown
+}
+
+// registryBlob returns the deck config, one of its layers or a blob of one
+// of its referrers. Content held in memory is not fetched again.
+func (ds *deckServer) registryBlob(ctx context.Context, cache *blobCache, dgst digest.Digest) (ocispec.Descriptor, []byte, error) {
+	if dgst == ds.manifest.Config.Digest {
+		return ds.manifest.Config, ds.configBytes, nil
+	}
+	for _, layer := range ds.manifest.Layers {
+		if layer.Digest != dgst {
+			continue
+		}
+		if data, ok := ds.images[layer.Annotations[ocispec.AnnotationTitle]]; ok {
+			return layer, data, nil
+		}
+		return ds.fetchFromSource(ctx, cache, layer)
+	}
+	g, err := ds.referrerGraph(ctx, cache)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	if desc, ok := g.blobs[dgst]; ok {
+		return ds.fetchFromSource(ctx, cache, desc)
+	}
+	return ocispec.Descriptor{}, nil, errContentUnknown
+}
+
+func (ds *deckServer) fetchFromSource(ctx context.Context, cache *blobCache, desc ocispec.Descriptor) (ocispec.Descriptor, []byte, error) {
+	if ds.src == nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("fetching %s: the deck source is not available", desc.Digest)
+	}
+	data, err := cache.fetch(ctx, ds.src, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("fetching %s: %w", desc.Digest, err)
+	}
+	return desc, data, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+func TestRegistryAPI(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+	source := fmt.Sprintf("%s/deck:v1", addr)
+	if err := pushDeck(ctx, source, writeDeckFile(t, []string{"2c", "ad"}), "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := opts.newRepository(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := upstream.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := oras.PackManifest(ctx, upstream, oras.PackManifestVersion1_1, "application/vnd.example.signature", oras.PackManifestOptions{Subject: &subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newDeckHandler()
+	ds, err := pullDeck(ctx, source, opts, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.store(ds)
+	ts := httptest.NewServer(newRegistryAPI([]deckSource{{"cards", source}}, []*deckHandler{h}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	// oras pulls the deck and its signature back out of the viewer.
+	repo, err := remote.NewRepository(u.Host + "/cards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.PlainHTTP = true
+	dst := memory.New()
+	desc, err := oras.ExtendedCopy(ctx, repo, "v1", dst, "v1", oras.DefaultExtendedCopyOptions)
+	if err != nil {
+		t.Fatalf("pulling from the registry API: %v", err)
+	}
+	if desc.Digest != subject.Digest {
+		t.Errorf("pulled %s, want %s", desc.Digest, subject.Digest)
+	}
+	pulled, err := loadDeck(ctx, dst, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled.cards) != 2 || len(pulled.images) != 2 {
+		t.Errorf("pulled %d cards and %d images, want 2 and 2", len(pulled.cards), len(pulled.images))
+	}
+	referrers, err := registry.Referrers(ctx, dst, desc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sig.Digest {
+		t.Errorf("referrers = %v, want %s", referrers, sig.Digest)
+	}
+
+	var tags struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	getJSON(t, ts.Config.Handler, "/v2/cards/tags/list", &tags)
+	if tags.Name != "cards" || len(tags.Tags) != 1 || tags.Tags[0] != "v1" {
+		t.Errorf("tags = %+v", tags)
+	}
+
+	var index ocispec.Index
+	w := httptest.NewRecorder()
+	ts.Config.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/v2/cards/referrers/"+subject.Digest.String()+"?artifactType=application/vnd.example.sbom", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 0 || w.Header().Get("OCI-Filters-Applied") != "artifactType" {
+		t.Errorf("filtered referrers = %v (filters %q), want none", index.Manifests, w.Header().Get("OCI-Filters-Applied"))
+	}
+
+	// Referrers pushed after the listing show up once it is stale.
+	sbom, err := oras.PackManifest(ctx, upstream, oras.PackManifestVersion1_1, "application/vnd.example.sbom", oras.PackManifestOptions{Subject: &subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.graphMu.Lock()
+	ds.graph.listed = time.Now().Add(-referrerGraphTTL)
+	ds.graphMu.Unlock()
+	w = httptest.NewRecorder()
+	ts.Config.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/v2/cards/referrers/"+subject.Digest.String()+"?artifactType=application/vnd.example.sbom", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != sbom.Digest {
+		t.Errorf("referrers after refresh = %v, want %s", index.Manifests, sbom.Digest)
+	}
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/v2/", http.StatusOK},
+		{"HEAD", "/v2/cards/manifests/v1", http.StatusOK},
+		{"GET", "/v2/cards/manifests/v2", http.StatusNotFound},
+		{"GET", "/v2/cards/manifests/" + ds.manifest.Layers[0].Digest.String(), http.StatusNotFound},
+		{"GET", "/v2/cards/blobs/" + ds.manifest.Layers[0].Digest.String(), http.StatusOK},
+		{"GET", "/v2/cards/blobs/sha256:0000", http.StatusBadRequest},
+		{"GET", "/v2/other/manifests/v1", http.StatusNotFound},
+		{"PUT", "/v2/cards/manifests/v2", http.StatusMethodNotAllowed},
+		{"DELETE", "/v2/cards/manifests/" + subject.Digest.String(), http.StatusMethodNotAllowed},
+		{"POST", "/v2/cards/blobs/uploads/", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ts.Config.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, w.Code, tt.want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// exportDeck writes the deck at source to dir as plain files: the card list
+// as deck.json, in the same format --deck reads, and each card image under
+// its original filename.
+func exportDeck(ctx context.Context, source, dir string, opts registryOptions) error {
+	ds, err := pullDeck(ctx, source, opts, nil, false)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	deckData, err := json.Marshal(ds.cards)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "deck.json"), append(deckData, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing deck file: %w", err)
+	}
+
+	names := make([]string, 0, len(ds.images))
+	for name := range ds.images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Titles come from the manifest; never let one escape dir.
+		if !filepath.IsLocal(name) || filepath.Base(name) != name {
+			return fmt.Errorf("refusing to write image with unsafe name %q", name)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), ds.images[name], 0o644); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+
+	fmt.Printf("Exported %d cards (%d images) to %s\n", len(ds.cards), len(names), dir)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry"
+)
+
+// deckRepository is a deck source whose tags can be enumerated.
+type deckRepository interface {
+	oras.ReadOnlyTarget
+	registry.TagLister
+}
+
+// openRepository opens the layout, archive or registry repository named by
+// source (see parseDeckRef). Any tag or digest in source is ignored.
+func openRepository(ctx context.Context, source string, opts registryOptions) (deckRepository, error) {
+	ref, err := parseDeckRef(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository reference: %w", err)
+	}
+
+	switch ref.Transport {
+	case transportOCI:
+		return openLayout(ctx, ref.Location)
+	case transportArchive:
+		return openArchive(ctx, ref.Location)
+	}
+
+	repo, err := opts.newRepository(ref.Location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository reference: %w", err)
+	}
+	return repo, nil
+}
+
+// errNotDeck marks a manifest that is not a card deck, such as a signature or
+// SBOM stored under a tag of its own.
+var errNotDeck = errors.New("not a card deck")
+
+// deckSummary describes one tagged deck version.
+type deckSummary struct {
+	Tag     string `json:"tag"`
+	Digest  string `json:"digest"`
+	Cards   int    `json:"cards"`
+	Size    int64  `json:"size"`
+	Created string `json:"created,omitempty"`
+}
+
+// summarizeDeck resolves tag in src and summarises the deck it points to.
+// Size counts the config and each distinct layer once.
+func summarizeDeck(ctx context.Context, src oras.ReadOnlyTarget, tag string) (deckSummary, error) {
+	desc, manifest, err := fetchManifest(ctx, src, tag)
+	if err != nil {
+		return deckSummary{}, err
+	}
+	if at := deckArtifactType(manifest); at != artifactType {
+		return deckSummary{}, fmt.Errorf("%w (artifact type %q)", errNotDeck, at)
+	}
+	cards, err := fetchCards(ctx, src, manifest)
+	if err != nil {
+		return deckSummary{}, err
+	}
+
+	size := manifest.Config.Size
+	seen := make(map[string]bool)
+	for _, layer := range manifest.Layers {
+		if seen[layer.Digest.String()] {
+			continue
+		}
+		seen[layer.Digest.String()] = true
+		size += layer.Size
+	}
+
+	return deckSummary{
+		Tag:     tag,
+		Digest:  desc.Digest.String(),
+		Cards:   len(cards),
+		Size:    size,
+		Created: manifest.Annotations[ocispec.AnnotationCreated],
+	}, nil
+}
+
+// listDecks summarises every tagged deck in the repository at source. Tags
+// holding something else are skipped with a warning.
+func listDecks(ctx context.Context, source string, opts registryOptions) ([]deckSummary, error) {
+	repo, err := openRepository(ctx, source, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	if err := repo.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("listing tags: %w", err)
+	}
+
+	summaries := make([]deckSummary, 0, len(tags))
+	for _, tag := range tags {
+		summary, err := summarizeDeck(ctx, repo, tag)
+		if errors.Is(err, errNotDeck) {
+			fmt.Fprintf(os.Stderr, "warning: skipping tag %s: %v\n", tag, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tag %s: %w", tag, err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// printDeckSummaries writes summaries to w as a table or as JSON.
+func printDeckSummaries(w io.Writer, summaries []deckSummary, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summaries)
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TAG\tDIGEST\tCARDS\tSIZE\tCREATED")
+		for _, s := range summaries {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", s.Tag, s.Digest, s.Cards, formatBytes(s.Size), s.Created)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q (want table or json)", format)
+}
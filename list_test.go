@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"oras.land/oras-go/v2"
+)
+
+func TestListDecksRegistry(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	deck1 := writeDeckFile(t, []string{"2c", "ad", "2c"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deck1, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deck2 := writeDeckFile(t, []string{"kh"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v2", addr), deck2, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A signature under a tag of its own is not listed.
+	repo, err := opts.newRepository(fmt.Sprintf("%s/deck", addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := repo.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := oras.PackManifest(ctx, repo, oras.PackManifestVersion1_1, "application/vnd.example.signature", oras.PackManifestOptions{Subject: &subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tag(ctx, sig, "v1.sig"); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := listDecks(ctx, fmt.Sprintf("%s/deck", addr), opts)
+	if err != nil {
+		t.Fatalf("listDecks failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("got %d decks, want 2", len(summaries))
+	}
+
+	v1 := summaries[0]
+	if v1.Tag != "v1" {
+		t.Fatalf("first tag = %q, want v1", v1.Tag)
+	}
+	if v1.Cards != 3 {
+		t.Errorf("v1 cards = %d, want 3", v1.Cards)
+	}
+	// Two unique card images plus the config.
+	if v1.Size <= 23590+36810 {
+		t.Errorf("v1 size = %d, want more than the two card images", v1.Size)
+	}
+	if !strings.HasPrefix(v1.Digest, "sha256:") {
+		t.Errorf("v1 digest = %q", v1.Digest)
+	}
+	if v1.Created == "" {
+		t.Error("v1 created annotation is empty")
+	}
+	if summaries[1].Tag != "v2" || summaries[1].Cards != 1 {
+		t.Errorf("v2 summary = %+v", summaries[1])
+	}
+}
+
+func TestListDecksLayout(t *testing.T) {
+	ctx := context.Background()
+	outputDir := filepath.Join(t.TempDir(), "deck-layout")
+	deckFile := writeDeckFile(t, []string{"2c", "ad"})
+	for _, tag := range []string{"latest", "v1"} {
+		if err := saveDeckLocal(ctx, outputDir, deckFile, "PNG-cards-1.3", tag, pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summaries, err := listDecks(ctx, outputDir, registryOptions{})
+	if err != nil {
+		t.Fatalf("listDecks failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("got %d decks, want 2", len(summaries))
+	}
+	for _, s := range summaries {
+		if s.Cards != 2 {
+			t.Errorf("%s cards = %d, want 2", s.Tag, s.Cards)
+		}
+	}
+}
+
+func TestPrintDeckSummaries(t *testing.T) {
+	summaries := []deckSummary{
+		{Tag: "v1", Digest: "sha256:abc", Cards: 8, Size: 2048, Created: "2025-01-01T00:00:00Z"},
+	}
+
+	var table bytes.Buffer
+	if err := printDeckSummaries(&table, summaries, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d table lines, want 2:\n%s", len(lines), table.String())
+	}
+	for _, want := range []string{"TAG", "DIGEST", "CARDS", "SIZE", "CREATED"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("header missing %s: %q", want, lines[0])
+		}
+	}
+	for _, want := range []string{"v1", "sha256:abc", "8", "2.0 KiB", "2025-01-01T00:00:00Z"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("row missing %s: %q", want, lines[1])
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printDeckSummaries(&out, summaries, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []deckSummary
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0] != summaries[0] {
+		t.Errorf("decoded = %+v, want %+v", decoded, summaries)
+	}
+
+	if err := printDeckSummaries(&out, summaries, "yaml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+)
+
+// defaultLockfile is where the lock command writes when no path is given.
+const defaultLockfile = "card-oci.lock"
+
+// lockfile pins deck references to the manifests they resolved to when the
+// lockfile was written, so later reads cannot pick up a re-tagged deck.
+type lockfile struct {
+	Decks []lockedDeck `json:"decks"`
+
+	path string
+}
+
+// lockedDeck is one pinned reference. Reference is kept exactly as it was
+// given to the lock command, version ranges included.
+type lockedDeck struct {
+	Reference string        `json:"reference"`
+	Tag       string        `json:"tag,omitempty"` // concrete tag the reference resolved to
+	Digest    digest.Digest `json:"digest"`
+}
+
+// readLockfile reads the lockfile at path. An empty path returns nil, which
+// leaves references unpinned.
+func readLockfile(path string) (*lockfile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lockfile: %w", err)
+	}
+	var l lockfile
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("parsing lockfile %s: %w", path, err)
+	}
+	for _, d := range l.Decks {
+		if err := d.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("lockfile %s: %s: %w", path, d.Reference, err)
+		}
+	}
+	l.path = path
+	return &l, nil
+}
+
+// lookup returns the entry pinning ref. A nil lockfile pins nothing.
+func (l *lockfile) lookup(ref string) (lockedDeck, bool) {
+	if l == nil {
+		return lockedDeck{}, false
+	}
+	for _, d := range l.Decks {
+		if d.Reference == ref {
+			return d, true
+		}
+	}
+	return lockedDeck{}, false
+}
+
+// open opens source through its lockfile entry rather than resolving it
+// again, so a version range keeps its locked release when newer ones appear.
+// The locked tag must still point at the locked digest, which is returned to
+// load. References missing from l are refused rather than read unpinned.
+func (l *lockfile) open(ctx context.Context, source string, opts registryOptions) (oras.ReadOnlyTarget, string, error) {
+	locked, ok := l.lookup(source)
+	if !ok {
+		return nil, "", fmt.Errorf("%s is not pinned in lockfile %s", source, l.path)
+	}
+	ref, err := parseDeckRef(source)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid source reference: %w", err)
+	}
+	src, _, err := openDeckTarget(ctx, ref, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if locked.Tag == "" {
+		// Pinned by digest alone; there is no tag to have moved.
+		if _, err := src.Resolve(ctx, locked.Digest.String()); err != nil {
+			return nil, "", fmt.Errorf("resolving %s@%s: %w", source, locked.Digest, err)
+		}
+		return src, locked.Digest.String(), nil
+	}
+	desc, err := src.Resolve(ctx, locked.Tag)
+	if err != nil {
+		return nil, "", fmt.Errorf("resolving locked tag %s of %s: %w", locked.Tag, source, err)
+	}
+	if desc.Digest != locked.Digest {
+		return nil, "", fmt.Errorf("%s: tag %s now points to %s, but lockfile %s pins %s", source, locked.Tag, desc.Digest, l.path, locked.Digest)
+	}
+	return src, locked.Digest.String(), nil
+}
+
+// lockDecks resolves each of refs and writes their tags and digests to the
+// lockfile at output, replacing any previous contents.
+func lockDecks(ctx context.Context, output string, refs []string, opts registryOptions) error {
+	if len(refs) == 0 {
+		return fmt.Errorf("no decks to lock")
+	}
+	opts.lock = nil
+
+	fmt.Printf("Locking %d decks into %s ...\n", len(refs), output)
+	l := lockfile{Decks: []lockedDeck{}}
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		var resolved string
+		var desc ocispec.Descriptor
+		err := opts.retryRead(ctx, "lock", func() error {
+			var src oras.ReadOnlyTarget
+			var err error
+			if src, resolved, err = openDeck(ctx, ref, opts); err != nil {
+				return err
+			}
+			if desc, _, err = fetchManifest(ctx, src, resolved); err != nil {
+				return fmt.Errorf("%s: %w", ref, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		tag := resolved
+		if _, err := digest.Parse(resolved); err == nil {
+			// Pinned by digest; keep the tag it was written with, if any.
+			tag = ""
+			if parsed, err := parseDeckRef(ref); err == nil {
+				tag = parsed.Tag
+			}
+		}
+		l.Decks = append(l.Decks, lockedDeck{Reference: ref, Tag: tag, Digest: desc.Digest})
+		fmt.Printf("  %s -> %s (%s)\n", ref, tag, desc.Digest)
+	}
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing lockfile: %w", err)
+	}
+	fmt.Println("Done.")
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLockDecks(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	for tag, cards := range map[string][]string{"1.2.0": {"2c"}, "1.2.5": {"ad"}} {
+		deck := writeDeckFile(t, cards)
+		if err := pushDeck(ctx, fmt.Sprintf("%s/deck:%s", addr, tag), deck, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ranged := fmt.Sprintf("%s/deck:~1.2", addr)
+	exact := fmt.Sprintf("%s/deck:1.2.0", addr)
+
+	path := filepath.Join(t.TempDir(), "decks.lock")
+	if err := lockDecks(ctx, path, []string{ranged, exact}, opts); err != nil {
+		t.Fatalf("lockDecks failed: %v", err)
+	}
+	lock, err := readLockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := opts.newRepository(exact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v125, err := repo.Resolve(ctx, "1.2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, ok := lock.lookup(ranged)
+	if !ok || locked.Tag != "1.2.5" || locked.Digest != v125.Digest {
+		t.Fatalf("lock entry for %s = %+v, %v; want 1.2.5 at %s", ranged, locked, ok, v125.Digest)
+	}
+
+	opts.lock = lock
+	_, ref, err := openDeck(ctx, ranged, opts)
+	if err != nil {
+		t.Fatalf("openDeck through lockfile failed: %v", err)
+	}
+	if ref != v125.Digest.String() {
+		t.Errorf("openDeck through lockfile = %s, want %s", ref, v125.Digest)
+	}
+
+	// Copies keep the locked tag even though they load by digest.
+	layout := filepath.Join(t.TempDir(), "layout")
+	if err := copyDeck(ctx, ranged, "oci:"+layout, opts, pushOptions{}); err != nil {
+		t.Fatalf("copyDeck through lockfile failed: %v", err)
+	}
+	if _, _, err := openDeck(ctx, "oci:"+layout+":1.2.5", registryOptions{}); err != nil {
+		t.Errorf("locked copy is missing tag 1.2.5: %v", err)
+	}
+
+	// Served decks keep it too, for the registry API's tag list.
+	ds, err := pullDeck(ctx, ranged, opts, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.tag != "1.2.5" {
+		t.Errorf("deck served through lockfile has tag %q, want 1.2.5", ds.tag)
+	}
+
+	err = exportDeck(ctx, fmt.Sprintf("%s/deck:1.2.5", addr), t.TempDir(), opts)
+	if err == nil || !strings.Contains(err.Error(), "not pinned") {
+		t.Errorf("export of unlocked reference error = %v, want not pinned", err)
+	}
+
+	// A newer release in range keeps the locked one; a moved tag breaks the
+	// lock.
+	newer := writeDeckFile(t, []string{"kh"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:1.2.7", addr), newer, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ref, err := openDeck(ctx, ranged, opts); err != nil || ref != v125.Digest.String() {
+		t.Errorf("openDeck(%s) after newer release = %s, %v; want %s", ranged, ref, err, v125.Digest)
+	}
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:1.2.5", addr), newer, "PNG-cards-1.3", opts, pushOptions{force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushDeck(ctx, exact, newer, "PNG-cards-1.3", opts, pushOptions{force: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{ranged, exact} {
+		_, _, err := openDeck(ctx, ref, opts)
+		if err == nil || !strings.Contains(err.Error(), "lockfile") {
+			t.Errorf("openDeck(%s) after re-tag error = %v, want lockfile mismatch", ref, err)
+		}
+	}
+}
@@ -2,40 +2,397 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
-func run() error {
-	target := flag.String("target", "", "registry reference (e.g. localhost:5000/deck:v1)")
-	local := flag.String("local", "", "output OCI layout directory (instead of pushing to registry)")
-	deck := flag.String("deck", "", "path to deck definition file")
-	images := flag.String("images", "PNG-cards-1.3", "path to card PNG directory")
-	plainHTTP := flag.Bool("plain-http", false, "use HTTP instead of HTTPS")
-	serve := flag.String("serve", "", "serve deck from OCI source (local dir or registry ref)")
-	flag.Parse()
+// stringList is a repeatable string flag.
+type stringList []string
 
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// addRegistryFlags registers the registry connection and authentication flags
+// on fs and returns the options they populate.
+func addRegistryFlags(fs *flag.FlagSet) *registryOptions {
+	opts := &registryOptions{}
+	fs.BoolVar(&opts.plainHTTP, "plain-http", false, "use HTTP instead of HTTPS")
+	fs.StringVar(&opts.username, "username", "", "registry username")
+	fs.StringVar(&opts.registryConfig, "registry-config", "", "path to registry credentials config file (default docker config)")
+	fs.BoolVar(&opts.passwordStdin, "password-stdin", false, "read registry password from stdin")
+	fs.StringVar(&opts.caFile, "ca-file", "", "PEM file of additional CA certificates to trust")
+	fs.StringVar(&opts.certFile, "cert-file", "", "client certificate for mutual TLS")
+	fs.StringVar(&opts.keyFile, "key-file", "", "client private key for mutual TLS")
+	fs.BoolVar(&opts.insecure, "insecure", false, "skip TLS certificate verification")
+	fs.IntVar(&opts.retries, "retries", defaultRetries, "retries for transient registry failures (0 disables)")
+	fs.DurationVar(&opts.retryBackoff, "retry-backoff", defaultRetryBackoff, "initial retry backoff, doubled on each attempt")
+	fs.DurationVar(&opts.retryMaxWait, "retry-max-wait", defaultRetryMaxWait, "maximum wait between retries")
+	fs.Var(&opts.progress, "progress", "progress display: auto, tty, plain or none")
+	return opts
+}
+
+// parseRegistryFlags parses args into fs and finishes populating opts,
+// reading the password from stdin when --password-stdin was given. A username
+// and --password-stdin must be given together.
+func parseRegistryFlags(fs *flag.FlagSet, args []string, opts *registryOptions) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if opts.passwordStdin && opts.username == "" {
+		return fmt.Errorf("--password-stdin requires --username")
+	}
+	if opts.username != "" && !opts.passwordStdin {
+		return fmt.Errorf("--username requires a password from --password-stdin")
+	}
+	if opts.passwordStdin {
+		if err := opts.readPassword(os.Stdin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runLogin(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: login [flags] <registry>")
+	}
+	return loginRegistry(ctx, fs.Arg(0), *opts)
+}
+
+func runLogout(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("logout", flag.ContinueOnError)
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: logout [flags] <registry>")
+	}
+	return logoutRegistry(ctx, fs.Arg(0), *opts)
+}
+
+func runList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	format := fs.String("output", "table", "output format: table or json")
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ls [flags] <repository or layout dir>")
+	}
+	var summaries []deckSummary
+	err := opts.retryRead(ctx, "ls", func() (err error) {
+		summaries, err = listDecks(ctx, fs.Arg(0), *opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return printDeckSummaries(os.Stdout, summaries, *format)
+}
+
+// deckArgs returns the tags or digests following the repository argument of
+// fs, or the one embedded in the repository reference when none follow.
+func deckArgs(fs *flag.FlagSet) ([]string, error) {
+	if fs.NArg() == 0 {
+		return nil, errors.New("missing repository")
+	}
+	if fs.NArg() > 1 {
+		return fs.Args()[1:], nil
+	}
+	ref, err := parseDeckRef(fs.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	if ref.Tag != "" {
+		return []string{ref.Tag}, nil
+	}
+	if ref.Digest != "" {
+		return []string{ref.Digest.String()}, nil
+	}
+	return nil, errors.New("missing tag")
+}
+
+func runUntag(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("untag", flag.ContinueOnError)
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	tags, err := deckArgs(fs)
+	if err != nil {
+		return fmt.Errorf("usage: untag [flags] <repository> <tag>... (or <repository>:<tag>)")
+	}
+	for _, tag := range tags {
+		if err := untagDeck(ctx, fs.Arg(0), tag, *opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runDelete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	refs, err := deckArgs(fs)
+	if err != nil {
+		return fmt.Errorf("usage: delete [flags] <repository> <tag or digest>... (or <repository>:<tag>)")
+	}
+	for _, ref := range refs {
+		if err := deleteDeck(ctx, fs.Arg(0), ref, *opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runGC(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "list unreferenced blobs without removing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: gc [--dry-run] <layout dir>")
+	}
+	ref, err := parseDeckRef(fs.Arg(0))
+	if err != nil || ref.Transport != transportOCI {
+		return fmt.Errorf("gc needs an OCI layout directory, got %s", fs.Arg(0))
+	}
+	_, err = gcLayout(ctx, os.Stdout, ref.Location, *dryRun)
+	return err
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("output", "text", "output format: text or json")
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: diff [flags] <ref-a> <ref-b>")
+	}
+	var d deckDiff
+	err := opts.retryRead(ctx, "diff", func() (err error) {
+		d, err = diffDecks(ctx, fs.Arg(0), fs.Arg(1), *opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return printDeckDiff(os.Stdout, d, *format)
+}
+
+func runStats(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	format := fs.String("output", "text", "output format: text or json")
+	top := fs.Int("top", 10, "number of most shared cards to show")
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: stats [flags] <repository or layout dir>")
+	}
+	var stats dedupStats
+	err := opts.retryRead(ctx, "stats", func() (err error) {
+		stats, err = collectStats(ctx, fs.Arg(0), *top, *opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return printStats(os.Stdout, stats, *format)
+}
+
+func runCopy(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("copy", flag.ContinueOnError)
+	var mountFrom stringList
+	fs.Var(&mountFrom, "mount-from", "repository on the destination registry to mount blobs from (repeatable)")
+	lockPath := fs.String("lockfile", "", "only copy sources pinned in this lockfile, by digest")
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	var err error
+	if opts.lock, err = readLockfile(*lockPath); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: copy [flags] <source> <destination>")
+	}
+	return copyDeck(ctx, fs.Arg(0), fs.Arg(1), *opts, pushOptions{mountFrom: mountFrom})
+}
+
+func runBundle(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	output := fs.String("output", "", "oci-archive tarball to write")
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	if *output == "" || fs.NArg() == 0 {
+		return fmt.Errorf("usage: bundle --output <file.tar> [flags] <ref>...")
+	}
+	return bundleDecks(ctx, *output, fs.Args(), *opts)
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	lockPath := fs.String("lockfile", "", "only export references pinned in this lockfile, by digest")
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	var err error
+	if opts.lock, err = readLockfile(*lockPath); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: export [flags] <ref> <output dir>")
+	}
+	return exportDeck(ctx, fs.Arg(0), fs.Arg(1), *opts)
+}
+
+func runLock(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	output := fs.String("lockfile", defaultLockfile, "lockfile to write")
+	opts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, opts); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: lock [flags] <ref>...")
+	}
+	return lockDecks(ctx, *output, fs.Args(), *opts)
+}
+
+func run(args []string) error {
 	ctx := context.Background()
 
+	if len(args) > 0 {
+		switch args[0] {
+		case "login":
+			return runLogin(ctx, args[1:])
+		case "logout":
+			return runLogout(ctx, args[1:])
+		case "ls":
+			return runList(ctx, args[1:])
+		case "untag":
+			return runUntag(ctx, args[1:])
+		case "delete":
+			return runDelete(ctx, args[1:])
+		case "gc":
+			return runGC(ctx, args[1:])
+		case "diff":
+			return runDiff(ctx, args[1:])
+		case "stats":
+			return runStats(ctx, args[1:])
+		case "copy":
+			return runCopy(ctx, args[1:])
+		case "bundle":
+			return runBundle(ctx, args[1:])
+		case "export":
+			return runExport(ctx, args[1:])
+		case "lock":
+			return runLock(ctx, args[1:])
+		}
+	}
+
+	fs := flag.NewFlagSet("card-oci", flag.ContinueOnError)
+	target := fs.String("target", "", "deck reference to publish to (e.g. localhost:5000/deck:v1 or oci:dir:v1)")
+	local := fs.String("local", "", "output OCI layout directory or .tar archive, optionally oci:dir:tag (instead of pushing to registry)")
+	deck := fs.String("deck", "", "path to deck definition file")
+	images := fs.String("images", "PNG-cards-1.3", "path to card PNG directory")
+	var serves stringList
+	fs.Var(&serves, "serve", "serve deck from OCI source (oci:dir:tag, oci-archive:file.tar:tag or registry ref), optionally as name=source (repeatable)")
+	serveConfig := fs.String("serve-config", "", "with --serve, JSON file listing more decks to serve as {\"decks\": [{\"name\": ..., \"source\": ...}]}")
+	serveRepo := fs.String("serve-repo", "", "serve every tagged deck in this repository, layout or archive")
+	var push pushOptions
+	fs.Var((*stringList)(&push.mountFrom), "mount-from", "repository on the target registry to mount existing blobs from (repeatable)")
+	fs.Var(&push.manifestVersion, "manifest-version", "OCI manifest version to pack: 1.0, 1.1 or auto")
+	fs.BoolVar(&push.force, "force", false, "move an existing tag that points at a different deck")
+	fs.BoolVar(&push.skipIdentical, "skip-identical", false, "do nothing when the tag already holds the same cards and images")
+	lockPath := fs.String("lockfile", "", "with --serve, only serve a reference pinned in this lockfile, by digest")
+	var serveOpts serveOptions
+	fs.StringVar(&serveOpts.listen, "listen", defaultListen, "address for --serve to listen on")
+	fs.StringVar(&serveOpts.tlsCert, "tls-cert", "", "PEM certificate for serving HTTPS (with --tls-key)")
+	fs.StringVar(&serveOpts.tlsKey, "tls-key", "", "PEM private key for --tls-cert")
+	fs.BoolVar(&serveOpts.registryAPI, "registry-api", false, "with --serve, also let OCI clients such as oras pull the served decks from /v2/ (read-only)")
+	fs.BoolVar(&serveOpts.lazyLayers, "lazy-layers", false, "with --serve, fetch card images on first request instead of before serving")
+	cacheSize := fs.Int64("cache-size", defaultCacheSize, "with --serve, MiB of card images to keep in memory (0 is unbounded)")
+	fs.DurationVar(&serveOpts.watchInterval, "watch-interval", 0, "with --serve, check the source this often and load a newly pushed deck (0 disables)")
+	regOpts := addRegistryFlags(fs)
+	if err := parseRegistryFlags(fs, args, regOpts); err != nil {
+		return err
+	}
+
 	switch {
-	case *serve != "":
-		return serveDeck(ctx, *serve, *plainHTTP)
+	case len(serves) > 0 || *serveConfig != "" || *serveRepo != "":
+		var err error
+		if regOpts.lock, err = readLockfile(*lockPath); err != nil {
+			return err
+		}
+		sources, err := collectDeckSources(ctx, serves, *serveConfig, *serveRepo, *regOpts)
+		if err != nil {
+			return err
+		}
+		if *cacheSize < 0 {
+			return fmt.Errorf("--cache-size must not be negative")
+		}
+		serveOpts.cacheSize = *cacheSize << 20
+		return serveDecks(ctx, sources, *regOpts, serveOpts)
 	case *local != "":
 		tag := "latest"
 		if *target != "" {
-			tag = parseRef(*target)
+			ref, err := parseDeckRef(*target)
+			if err != nil {
+				return fmt.Errorf("invalid target reference: %w", err)
+			}
+			if ref.Range != "" {
+				return fmt.Errorf("invalid target reference %q: saving needs a tag, not a version range", *target)
+			}
+			if ref.Tag != "" {
+				tag = ref.Tag
+			}
 		}
-		return saveDeckLocal(ctx, *local, *deck, *images, tag)
+		return saveDeckLocal(ctx, *local, *deck, *images, tag, push)
 	case *target != "":
-		return pushDeck(ctx, *target, *deck, *images, *plainHTTP)
+		ref, err := parseDeckRef(*target)
+		if err != nil {
+			return fmt.Errorf("invalid target reference: %w", err)
+		}
+		if ref.Transport != transportRegistry {
+			return saveDeckLocal(ctx, *target, *deck, *images, "latest", push)
+		}
+		return pushDeck(ctx, ref.remote(), *deck, *images, *regOpts, push)
 	default:
 		return fmt.Errorf("either --target, --local, or --serve is required")
 	}
 }
 
 func main() {
-	if err := run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
@@ -1,20 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/olareg/olareg"
 	"github.com/olareg/olareg/config"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/memory"
 	"oras.land/oras-go/v2/registry/remote"
 )
 
@@ -96,22 +100,59 @@ func TestShorthandToFilename(t *testing.T) {
 	}
 }
 
-func TestParseRef(t *testing.T) {
+func TestParseImageReference(t *testing.T) {
+	const dgst = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
 	tests := []struct {
 		input string
-		want  string
+		want  imageReference
+		ref   string
 	}{
-		{"localhost:5000/deck:v1", "v1"},
-		{"localhost:5000/deck:latest", "latest"},
-		{"myregistry.io/ns/repo:v2", "v2"},
-		{"localhost:5000/deck", "latest"},
-		{"ghcr.io/user/repo@sha256:abc123", "sha256:abc123"},
+		{"localhost:5000/deck:v1", imageReference{"localhost:5000", "deck", "v1", "", ""}, "v1"},
+		{"localhost:5000/deck:latest", imageReference{"localhost:5000", "deck", "latest", "", ""}, "latest"},
+		{"myregistry.io/ns/repo:v2", imageReference{"myregistry.io", "ns/repo", "v2", "", ""}, "v2"},
+		{"localhost:5000/deck", imageReference{"localhost:5000", "deck", "", "", ""}, "latest"},
+		{"localhost/deck:v1", imageReference{"localhost", "deck", "v1", "", ""}, "v1"},
+		{"ghcr.io/user/repo@" + dgst, imageReference{"ghcr.io", "user/repo", "", "", dgst}, dgst},
+		{"ghcr.io/user/repo:v1@" + dgst, imageReference{"ghcr.io", "user/repo", "v1", "", dgst}, dgst},
+		{"user/repo:v1", imageReference{defaultRegistry, "user/repo", "v1", "", ""}, "v1"},
+		{"deck", imageReference{defaultRegistry, "library/deck", "", "", ""}, "latest"},
 	}
 	for _, tc := range tests {
 		t.Run(tc.input, func(t *testing.T) {
-			got := parseRef(tc.input)
+			got, err := parseImageReference(tc.input)
+			if err != nil {
+				t.Fatalf("parseImageReference(%q) failed: %v", tc.input, err)
+			}
 			if got != tc.want {
-				t.Errorf("parseRef(%q) = %q, want %q", tc.input, got, tc.want)
+				t.Errorf("parseImageReference(%q) = %+v, want %+v", tc.input, got, tc.want)
+			}
+			if got.reference() != tc.ref {
+				t.Errorf("reference() = %q, want %q", got.reference(), tc.ref)
+			}
+		})
+	}
+}
+
+func TestParseImageReferenceErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "empty reference"},
+		{"ghcr.io/user/repo@sha256:abc123", "bad digest"},
+		{"ghcr.io/user/repo@", "bad digest"},
+		{"ghcr.io/user/repo:-v1", "bad tag"},
+		{"ghcr.io/user/repo:", "bad tag"},
+		{"ghcr.io/User/Repo:v1", "bad repository"},
+		{"ghcr.io/", "missing repository"},
+		{"ghcr.io/user//repo", "bad repository"},
+		{"bad host:5000/repo", "bad registry"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := parseImageReference(tc.input)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("parseImageReference(%q) error = %v, want %q", tc.input, err, tc.want)
 			}
 		})
 	}
@@ -154,7 +195,7 @@ func TestPushDeck(t *testing.T) {
 	ctx := context.Background()
 	target := fmt.Sprintf("%s/deck:v1", addr)
 
-	err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", true)
+	err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", registryOptions{plainHTTP: true}, pushOptions{})
 	if err != nil {
 		t.Fatalf("pushDeck failed: %v", err)
 	}
@@ -242,13 +283,13 @@ func TestPushDeckDeduplication(t *testing.T) {
 	// Push first deck with 2c and ad.
 	deck1 := writeDeckFile(t, []string{"2c", "ad"})
 	target1 := fmt.Sprintf("%s/deck:v1", addr)
-	if err := pushDeck(ctx, target1, deck1, "PNG-cards-1.3", true); err != nil {
+	if err := pushDeck(ctx, target1, deck1, "PNG-cards-1.3", registryOptions{plainHTTP: true}, pushOptions{}); err != nil {
 		t.Fatalf("pushDeck v1 failed: %v", err)
 	}
 
 	// Build second deck sharing 2c but adding kh.
 	deck2 := writeDeckFile(t, []string{"2c", "kh"})
-	store, err := buildDeck(ctx, deck2, "PNG-cards-1.3", "v2")
+	store, err := buildDeck(ctx, deck2, "PNG-cards-1.3", "v2", manifest1_1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -294,7 +335,7 @@ func TestSaveDeckLocal(t *testing.T) {
 	outputDir := filepath.Join(t.TempDir(), "deck-layout")
 
 	ctx := context.Background()
-	err := saveDeckLocal(ctx, outputDir, deckFile, "PNG-cards-1.3", "v1")
+	err := saveDeckLocal(ctx, outputDir, deckFile, "PNG-cards-1.3", "v1", pushOptions{})
 	if err != nil {
 		t.Fatalf("saveDeckLocal failed: %v", err)
 	}
@@ -378,7 +419,7 @@ func TestSaveDeckLocal(t *testing.T) {
 
 func TestSaveDeckLocalBadDeck(t *testing.T) {
 	outputDir := filepath.Join(t.TempDir(), "deck-layout")
-	err := saveDeckLocal(context.Background(), outputDir, "/nonexistent/deck.txt", "PNG-cards-1.3", "v1")
+	err := saveDeckLocal(context.Background(), outputDir, "/nonexistent/deck.txt", "PNG-cards-1.3", "v1", pushOptions{})
 	if err == nil {
 		t.Fatal("expected error for missing deck file")
 	}
@@ -387,7 +428,7 @@ func TestSaveDeckLocalBadDeck(t *testing.T) {
 func TestPushDeckBadDeckFile(t *testing.T) {
 	addr := setupRegistry(t)
 	target := fmt.Sprintf("%s/deck:v1", addr)
-	err := pushDeck(context.Background(), target, "/nonexistent/deck.txt", "PNG-cards-1.3", true)
+	err := pushDeck(context.Background(), target, "/nonexistent/deck.txt", "PNG-cards-1.3", registryOptions{plainHTTP: true}, pushOptions{})
 	if err == nil {
 		t.Fatal("expected error for missing deck file")
 	}
@@ -397,7 +438,7 @@ func TestPushDeckBadCard(t *testing.T) {
 	addr := setupRegistry(t)
 	deckFile := writeDeckFile(t, []string{"zz"})
 	target := fmt.Sprintf("%s/deck:v1", addr)
-	err := pushDeck(context.Background(), target, deckFile, "PNG-cards-1.3", true)
+	err := pushDeck(context.Background(), target, deckFile, "PNG-cards-1.3", registryOptions{plainHTTP: true}, pushOptions{})
 	if err == nil {
 		t.Fatal("expected error for invalid card shorthand")
 	}
@@ -407,8 +448,238 @@ func TestPushDeckMissingImage(t *testing.T) {
 	addr := setupRegistry(t)
 	deckFile := writeDeckFile(t, []string{"2c"})
 	target := fmt.Sprintf("%s/deck:v1", addr)
-	err := pushDeck(context.Background(), target, deckFile, "/nonexistent/images", true)
+	err := pushDeck(context.Background(), target, deckFile, "/nonexistent/images", registryOptions{plainHTTP: true}, pushOptions{})
 	if err == nil {
 		t.Fatal("expected error for missing image directory")
 	}
 }
+
+// setupLegacyRegistry starts an in-memory olareg registry that behaves like a
+// registry predating OCI 1.1: it answers the referrers API with status and
+// rejects manifests that carry an artifactType.
+func setupLegacyRegistry(t *testing.T, status int) string {
+	t.Helper()
+	regHandler := olareg.New(config.Config{
+		Storage: config.ConfigStorage{
+			StoreType: config.StoreMem,
+		},
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/referrers/") {
+			w.WriteHeader(status)
+			return
+		}
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if bytes.Contains(body, []byte(`"artifactType"`)) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"errors":[{"code":"MANIFEST_INVALID","message":"unknown field artifactType"}]}`)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		regHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+		regHandler.Close()
+	})
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func TestPushDeckManifestVersions(t *testing.T) {
+	deckFile := writeDeckFile(t, []string{"2c", "ad"})
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	addr := setupLegacyRegistry(t, http.StatusNotFound)
+	force11 := pushOptions{manifestVersion: manifest1_1}
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deckFile, "PNG-cards-1.3", opts, force11); err == nil {
+		t.Error("expected OCI 1.1 push to a legacy registry to fail")
+	}
+
+	tests := []struct {
+		name    string
+		addr    string
+		version manifestVersion
+		want    string // expected manifest artifactType
+	}{
+		{"auto legacy", addr, manifestAuto, ""},
+		{"1.0 legacy", addr, manifest1_0, ""},
+		{"auto legacy 400", setupLegacyRegistry(t, http.StatusBadRequest), manifestAuto, ""},
+		{"auto legacy 405", setupLegacyRegistry(t, http.StatusMethodNotAllowed), manifestAuto, ""},
+		{"auto legacy 501", setupLegacyRegistry(t, http.StatusNotImplemented), manifestAuto, ""},
+		{"auto current", setupRegistry(t), manifestAuto, artifactType},
+		{"1.0 current", setupRegistry(t), manifest1_0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := fmt.Sprintf("%s/deck:v1", tt.addr)
+			// The legacy cases share a registry and tag.
+			push := pushOptions{manifestVersion: tt.version, force: true}
+			if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", opts, push); err != nil {
+				t.Fatalf("pushDeck failed: %v", err)
+			}
+
+			src, tag, err := openDeck(ctx, target, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, manifest, err := fetchManifest(ctx, src, tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.ArtifactType != tt.want {
+				t.Errorf("artifact type = %q, want %q", manifest.ArtifactType, tt.want)
+			}
+			if manifest.Config.MediaType != configMediaType {
+				t.Errorf("config media type = %q, want %q", manifest.Config.MediaType, configMediaType)
+			}
+
+			ds, err := loadDeck(ctx, src, tag)
+			if err != nil {
+				t.Fatalf("loadDeck failed: %v", err)
+			}
+			if len(ds.cards) != 2 || len(ds.images) != 2 {
+				t.Errorf("got %d cards and %d images, want 2 and 2", len(ds.cards), len(ds.images))
+			}
+		})
+	}
+}
+
+func TestLoadDeckRejectsOtherArtifacts(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	desc, err := oras.PackManifest(ctx, store, oras.PackManifestVersion1_1, "application/vnd.example.other", oras.PackManifestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(ctx, desc, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDeck(ctx, store, "v1"); err == nil || !strings.Contains(err.Error(), "not a card deck") {
+		t.Errorf("loadDeck error = %v, want not a card deck", err)
+	}
+}
+
+func TestParseManifestVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    manifestVersion
+		wantErr bool
+	}{
+		{"", manifestAuto, false},
+		{"auto", manifestAuto, false},
+		{"1.0", manifest1_0, false},
+		{"1.1", manifest1_1, false},
+		{"1.2", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseManifestVersion(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseManifestVersion(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseManifestVersion(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+		if !tt.wantErr && tt.in != "" && got.String() != tt.in {
+			t.Errorf("String() = %q, want %q", got.String(), tt.in)
+		}
+	}
+}
+
+func TestPushDeckTagGuard(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+	target := fmt.Sprintf("%s/deck:v1", addr)
+
+	deckA := writeDeckFile(t, []string{"2c", "ad"})
+	deckB := writeDeckFile(t, []string{"kh"})
+	if err := pushDeck(ctx, target, deckA, "PNG-cards-1.3", opts, pushOptions{manifestVersion: manifest1_1}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := opts.newRepository(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := repo.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pushDeck(ctx, target, deckB, "PNG-cards-1.3", opts, pushOptions{})
+	if err == nil || !strings.Contains(err.Error(), first.Digest.String()) || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("pushing a different deck over v1 error = %v, want refusal naming %s", err, first.Digest)
+	}
+	if desc, _ := repo.Resolve(ctx, "v1"); desc.Digest != first.Digest {
+		t.Errorf("refused push moved v1 to %s", desc.Digest)
+	}
+
+	// The same deck under a different manifest digest is still refused
+	// without --skip-identical.
+	if err := pushDeck(ctx, target, deckA, "PNG-cards-1.3", opts, pushOptions{manifestVersion: manifest1_0}); err == nil {
+		t.Fatal("expected republishing the same deck as a different manifest to be refused")
+	}
+	if desc, _ := repo.Resolve(ctx, "v1"); desc.Digest != first.Digest {
+		t.Errorf("refused republish moved v1 to %s", desc.Digest)
+	}
+
+	// Same cards packed differently still count as identical.
+	if err := pushDeck(ctx, target, deckA, "PNG-cards-1.3", opts, pushOptions{manifestVersion: manifest1_0, skipIdentical: true}); err != nil {
+		t.Fatalf("identical push with --skip-identical failed: %v", err)
+	}
+	if desc, _ := repo.Resolve(ctx, "v1"); desc.Digest != first.Digest {
+		t.Errorf("identical push moved v1 to %s", desc.Digest)
+	}
+
+	if err := pushDeck(ctx, target, deckB, "PNG-cards-1.3", opts, pushOptions{force: true}); err != nil {
+		t.Fatalf("forced push failed: %v", err)
+	}
+	if desc, _ := repo.Resolve(ctx, "v1"); desc.Digest == first.Digest {
+		t.Error("forced push did not move v1")
+	}
+}
+
+func TestSaveDeckLocalTagGuard(t *testing.T) {
+	ctx := context.Background()
+	deckA := writeDeckFile(t, []string{"2c"})
+	deckB := writeDeckFile(t, []string{"kh"})
+
+	tmp := t.TempDir()
+	for _, output := range []string{"oci:" + filepath.Join(tmp, "layout"), "oci-archive:" + filepath.Join(tmp, "deck.tar")} {
+		if err := saveDeckLocal(ctx, output+":v1", deckA, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := saveDeckLocal(ctx, output+":v1", deckB, "PNG-cards-1.3", "latest", pushOptions{}); err == nil {
+			t.Errorf("%s: expected refusal to move v1", output)
+		}
+		if err := saveDeckLocal(ctx, output+":v1", deckA, "PNG-cards-1.3", "latest", pushOptions{manifestVersion: manifest1_0, skipIdentical: true}); err != nil {
+			t.Errorf("%s: identical save failed: %v", output, err)
+		}
+		if err := saveDeckLocal(ctx, output+":v1", deckB, "PNG-cards-1.3", "latest", pushOptions{force: true}); err != nil {
+			t.Fatalf("%s: forced save failed: %v", output, err)
+		}
+		src, tag, err := openDeck(ctx, output+":v1", registryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := loadDeck(ctx, src, tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ds.cards) != 1 || ds.cards[0] != "kh" {
+			t.Errorf("%s: v1 = %v after forced save, want [kh]", output, ds.cards)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// imageRoutes are the routes whose successful responses count as images
+// served.
+var imageRoutes = map[string]bool{"/images/": true, "GET /blobs/{digest}": true}
+
+// serverMetrics collects what /metrics reports in the Prometheus text format.
+// Requests are recorded as they finish; the decks and the layer cache are
+// read when scraped.
+type serverMetrics struct {
+	sources []deckSource
+	decks   []*deckHandler
+	cache   *blobCache
+
+	mu         sync.Mutex
+	routes     map[string]*routeStats
+	imageBytes int64
+}
+
+type routeStats struct {
+	codes   map[int]int64
+	buckets []int64 // per latencyBuckets, not cumulative
+	count   int64
+	sum     float64
+}
+
+func newServerMetrics(sources []deckSource, decks []*deckHandler, cache *blobCache) *serverMetrics {
+	return &serverMetrics{sources: sources, decks: decks, cache: cache, routes: make(map[string]*routeStats)}
+}
+
+type routeKey struct{}
+
+// noteRoute records the pattern r was routed by for the request metrics.
+// Muxes call it once they have served r; the innermost one, which runs
+// first, names the route.
+func noteRoute(r *http.Request) {
+	if route, ok := r.Context().Value(routeKey{}).(*string); ok && *route == "" {
+		*route = r.Pattern
+	}
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// event streams use to flush.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// instrument wraps next to count requests and their latency by route.
+func (m *serverMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := new(string)
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+		next.ServeHTTP(rec, r)
+		noteRoute(r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if *route == "" {
+			*route = "unmatched"
+		}
+		m.observe(*route, rec.status, rec.bytes, time.Since(start))
+	})
+}
+
+func (m *serverMetrics) observe(route string, status int, size int64, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.routes[route]
+	if !ok {
+		s = &routeStats{codes: make(map[int]int64), buckets: make([]int64, len(latencyBuckets))}
+		m.routes[route] = s
+	}
+	s.codes[status]++
+	s.count++
+	s.sum += d.Seconds()
+	if i, _ := slices.BinarySearch(latencyBuckets, d.Seconds()); i < len(latencyBuckets) {
+		s.buckets[i]++
+	}
+	if imageRoutes[route] && status < http.StatusMultipleChoices {
+		m.imageBytes += size
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes metric families in the Prometheus text format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample; labels alternate names and values.
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(mw.w, "%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (m *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mw := metricsWriter{w}
+	m.writeRequests(mw)
+	m.writeCache(mw)
+	m.writeDecks(mw)
+}
+
+func (m *serverMetrics) writeRequests(mw metricsWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	routes := make([]string, 0, len(m.routes))
+	for route := range m.routes {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+
+	mw.family("card_oci_http_requests_total", "counter", "HTTP requests by route and status code.")
+	for _, route := range routes {
+		s := m.routes[route]
+		codes := make([]int, 0, len(s.codes))
+		for code := range s.codes {
+			codes = append(codes, code)
+		}
+		slices.Sort(codes)
+		for _, code := range codes {
+			mw.sample("card_oci_http_requests_total", float64(s.codes[code]), "route", route, "code", strconv.Itoa(code))
+		}
+	}
+
+	mw.family("card_oci_http_request_duration_seconds", "histogram", "HTTP request latency by route.")
+	for _, route := range routes {
+		s := m.routes[route]
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += s.buckets[i]
+			mw.sample("card_oci_http_request_duration_seconds_bucket", float64(cumulative), "route", route, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		mw.sample("card_oci_http_request_duration_seconds_bucket", float64(s.count), "route", route, "le", "+Inf")
+		mw.sample("card_oci_http_request_duration_seconds_sum", s.sum, "route", route)
+		mw.sample("card_oci_http_request_duration_seconds_count", float64(s.count), "route", route)
+	}
+
+	mw.family("card_oci_image_bytes_served_total", "counter", "Bytes of card images sent to clients.")
+	mw.sample("card_oci_image_bytes_served_total", float64(m.imageBytes))
+}
+
+func (m *serverMetrics) writeCache(mw metricsWriter) {
+	s := m.cache.snapshot()
+	mw.family("card_oci_cache_hits_total", "counter", "Layer cache lookups served without a fetch.")
+	mw.sample("card_oci_cache_hits_total", float64(s.Hits))
+	mw.family("card_oci_cache_misses_total", "counter", "Layer cache lookups that fetched from the source.")
+	mw.sample("card_oci_cache_misses_total", float64(s.Misses))
+	mw.family("card_oci_cache_hit_ratio", "gauge", "Share of layer cache lookups served without a fetch.")
+	ratio := 0.0
+	if total := s.Hits + s.Misses; total > 0 {
+		ratio = float64(s.Hits) / float64(total)
+	}
+	mw.sample("card_oci_cache_hit_ratio", ratio)
+	mw.family("card_oci_cache_evictions_total", "counter", "Layers evicted from the cache to stay within its size.")
+	mw.sample("card_oci_cache_evictions_total", float64(s.Evictions))
+	mw.family("card_oci_cache_bytes", "gauge", "Bytes of layers held in the cache.")
+	mw.sample("card_oci_cache_bytes", float64(s.Bytes))
+	mw.family("card_oci_cache_max_bytes", "gauge", "Size the cache is bounded to; 0 when unbounded.")
+	mw.sample("card_oci_cache_max_bytes", float64(s.MaxBytes))
+
+	mw.family("card_oci_registry_fetch_errors_total", "counter", "Failed fetches from deck sources, by operation.")
+	mw.sample("card_oci_registry_fetch_errors_total", float64(s.Errors), "op", "layer")
+}
+
+func (m *serverMetrics) writeDecks(mw metricsWriter) {
+	type loaded struct {
+		src deckSource
+		ds  *deckServer
+	}
+	var decks []loaded
+	for i, h := range m.decks {
+		if ds := h.deck.Load(); ds != nil {
+			decks = append(decks, loaded{m.sources[i], ds})
+		}
+	}
+
+	mw.family("card_oci_deck_info", "gauge", "The deck being served, labelled with its manifest digest.")
+	for _, d := range decks {
+		mw.sample("card_oci_deck_info", 1, "deck", d.src.Name, "source", d.src.Source, "digest", d.ds.manifestDesc.Digest.String())
+	}
+	mw.family("card_oci_deck_cards", "gauge", "Cards in the deck being served.")
+	for _, d := range decks {
+		mw.sample("card_oci_deck_cards", float64(len(d.ds.cards)), "deck", d.src.Name)
+	}
+	mw.family("card_oci_deck_load_duration_seconds", "gauge", "Time taken to load the deck being served.")
+	for _, d := range decks {
+		mw.sample("card_oci_deck_load_duration_seconds", d.ds.loadDuration.Seconds(), "deck", d.src.Name)
+	}
+	// Counted for decks that have not loaded yet too.
+	mw.family("card_oci_reload_errors_total", "counter", "Failed checks of the deck's source for a new deck under --watch-interval.")
+	for i, h := range m.decks {
+		mw.sample("card_oci_reload_errors_total", float64(h.reloadErrors.Load()), "deck", m.sources[i].Name)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerMetrics(t *testing.T) {
+	ctx := context.Background()
+	layout := filepath.Join(t.TempDir(), "deck-layout")
+	if err := saveDeckLocal(ctx, layout, writeDeckFile(t, []string{"2c", "ad"}), "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cache := newBlobCache(0)
+	ds, err := pullDeck(ctx, layout, registryOptions{progress: progressNone}, cache, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newDeckHandler()
+	h.cache = cache
+	h.store(ds)
+	h.reloadErrors.Add(1)
+	sources := []deckSource{{"cards", layout}}
+	handler := serverHandler(h, sources, []*deckHandler{h}, cache, true)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	layer, _ := ds.cardLayer("ad")
+	get("/")
+	get("/blobs/" + layer.Digest.String())
+	get("/blobs/" + layer.Digest.String())
+	get("/blobs/sha256:0000")
+	get("/v2/cards/tags/list")
+
+	w := get("/metrics")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("/metrics = %d (%s)", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`card_oci_http_requests_total{route="/",code="200"} 1`,
+		`card_oci_http_requests_total{route="GET /blobs/{digest}",code="200"} 2`,
+		`card_oci_http_requests_total{route="GET /blobs/{digest}",code="404"} 1`,
+		`card_oci_http_requests_total{route="GET /v2/{name}/tags/list",code="200"} 1`,
+		`card_oci_http_request_duration_seconds_bucket{route="GET /blobs/{digest}",le="+Inf"} 3`,
+		`card_oci_http_request_duration_seconds_count{route="GET /blobs/{digest}"} 3`,
+		fmt.Sprintf("card_oci_image_bytes_served_total %d\n", 2*layer.Size),
+		"card_oci_cache_hits_total 1\n",
+		"card_oci_cache_misses_total 1\n",
+		"card_oci_cache_hit_ratio 0.5\n",
+		`card_oci_registry_fetch_errors_total{op="layer"} 0`,
+		fmt.Sprintf(`card_oci_deck_info{deck="cards",source=%q,digest=%q} 1`, layout, ds.manifestDesc.Digest),
+		`card_oci_deck_cards{deck="cards"} 2`,
+		`card_oci_deck_load_duration_seconds{deck="cards"} `,
+		`card_oci_reload_errors_total{deck="cards"} 1`,
+		"# TYPE card_oci_http_request_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	var b strings.Builder
+	metricsWriter{&b}.sample("m", 1, "source", `oci:C:\decks "new"`+"\n")
+	if got, want := b.String(), `m{source="oci:C:\\decks \"new\"\n"} 1`+"\n"; got != want {
+		t.Errorf("sample = %q, want %q", got, want)
+	}
+}
@@ -2,49 +2,178 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2"
 	"oras.land/oras-go/v2/content/memory"
 	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
 	"oras.land/oras-go/v2/registry/remote"
 	"oras.land/oras-go/v2/registry/remote/auth"
-	"oras.land/oras-go/v2/registry/remote/credentials"
 )
 
 const (
 	artifactType    = "application/vnd.card-deck"
 	configMediaType = "application/vnd.card-deck.config+json"
+	cardAnnotation  = "io.github.card-deck.card"
 )
 
-// parseRef extracts the tag from a registry reference like "localhost:5000/repo:tag".
-// parseRef extracts the tag or digest reference from a registry reference.
-// Examples:
-//
-//	"localhost:5000/deck:v1"          → "v1"
-//	"ghcr.io/user/repo@sha256:abc…"  → "sha256:abc…"
-//	"localhost:5000/deck"             → "latest"
-func parseRef(target string) string {
-	if i := strings.LastIndex(target, "@"); i >= 0 {
-		return target[i+1:]
-	}
-	parts := strings.SplitN(target, ":", 3)
-	if len(parts) == 3 {
-		return parts[2]
-	}
-	if len(parts) == 2 && !strings.Contains(parts[1], "/") {
-		return parts[1]
-	}
-	return "latest"
+// manifestVersion selects the OCI image-spec version decks are packed with.
+type manifestVersion int
+
+const (
+	manifestAuto manifestVersion = iota // 1.1 unless the registry lacks the referrers API
+	manifest1_0                         // artifact type carried only by the config media type
+	manifest1_1                         // artifactType field set on the manifest
+)
+
+func parseManifestVersion(s string) (manifestVersion, error) {
+	switch s {
+	case "auto", "":
+		return manifestAuto, nil
+	case "1.0":
+		return manifest1_0, nil
+	case "1.1":
+		return manifest1_1, nil
+	}
+	return 0, fmt.Errorf("invalid manifest version %q (want 1.0, 1.1 or auto)", s)
+}
+
+func (v manifestVersion) String() string {
+	switch v {
+	case manifest1_0:
+		return "1.0"
+	case manifest1_1:
+		return "1.1"
+	}
+	return "auto"
+}
+
+// Set implements flag.Value.
+func (v *manifestVersion) Set(s string) error {
+	parsed, err := parseManifestVersion(s)
+	if err != nil {
+		return err
+	}
+	*v = parsed
+	return nil
+}
+
+// pushOptions holds settings that control how a deck is published.
+type pushOptions struct {
+	mountFrom       []string        // repositories on the target registry to mount shared blobs from
+	manifestVersion manifestVersion // image-spec version to pack the manifest with
+	force           bool            // move an existing tag even when it points at another manifest
+	skipIdentical   bool            // leave an existing tag alone when it already holds the same deck
+}
+
+// errTagUnchanged reports that a push was skipped because the tag already
+// holds the same deck.
+var errTagUnchanged = errors.New("tag unchanged")
+
+// checkTag decides whether tag in dst may be pointed at the deck tagged tag in
+// store. A tag that does not exist yet or already points at the same manifest
+// is fine; moving it to a different manifest needs push.force. With
+// push.skipIdentical, a tag holding the same cards and images returns
+// errTagUnchanged even if the manifests differ, for example only by their
+// creation time.
+func checkTag(ctx context.Context, dst oras.ReadOnlyTarget, store oras.ReadOnlyTarget, tag string, push pushOptions) error {
+	if push.force && !push.skipIdentical {
+		return nil
+	}
+	oldDesc, err := dst.Resolve(ctx, tag)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("resolving existing tag %q: %w", tag, err)
+	}
+	newDesc, newManifest, err := fetchManifest(ctx, store, tag)
+	if err != nil {
+		return err
+	}
+
+	if push.skipIdentical {
+		if oldDesc.Digest == newDesc.Digest {
+			return errTagUnchanged
+		}
+		_, oldManifest, err := fetchManifest(ctx, dst, oldDesc.Digest.String())
+		if err != nil {
+			return err
+		}
+		if sameDeckContent(oldManifest, newManifest) {
+			return errTagUnchanged
+		}
+	}
+	if push.force || oldDesc.Digest == newDesc.Digest {
+		return nil
+	}
+	return fmt.Errorf("tag %q already points to %s; refusing to replace it with %s (use --force to overwrite)", tag, oldDesc.Digest, newDesc.Digest)
+}
+
+// sameDeckContent reports whether a and b describe the same deck: the same
+// artifact type, config and layers, ignoring manifest annotations.
+func sameDeckContent(a, b v1.Manifest) bool {
+	if deckArtifactType(a) != deckArtifactType(b) || a.Config.Digest != b.Config.Digest || len(a.Layers) != len(b.Layers) {
+		return false
+	}
+	for i := range a.Layers {
+		if a.Layers[i].Digest != b.Layers[i].Digest {
+			return false
+		}
+	}
+	return true
+}
+
+// deckArtifactType returns the artifact type of manifest. OCI 1.0 manifests
+// have no artifactType field, so a deck config media type implies a deck.
+func deckArtifactType(manifest v1.Manifest) string {
+	if manifest.ArtifactType != "" {
+		return manifest.ArtifactType
+	}
+	if manifest.Config.MediaType == configMediaType {
+		return artifactType
+	}
+	return manifest.Config.MediaType
+}
+
+// probeManifestVersion reports which manifest version repo accepts. Registries
+// that implement the OCI 1.1 referrers API also accept artifactType; any other
+// answer from that endpoint apart from an auth failure marks an older registry.
+func probeManifestVersion(ctx context.Context, repo *remote.Repository) (manifestVersion, error) {
+	scheme := "https"
+	if repo.PlainHTTP {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s/referrers/%s", scheme, repo.Reference.Host(), repo.Reference.Repository, digest.FromBytes(nil))
+	ctx = auth.AppendRepositoryScope(ctx, repo.Reference, auth.ActionPull)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := repo.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("probing registry: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return manifest1_1, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return 0, fmt.Errorf("probing registry: %s", resp.Status)
+	}
+	return manifest1_0, nil
 }
 
 // buildDeck reads the deck file, loads card PNGs, and packs them into an in-memory
-// OCI store tagged with the given tag.
-func buildDeck(ctx context.Context, deckPath, imagesDir, tag string) (*memory.Store, error) {
+// OCI store tagged with the given tag. manifestAuto packs an OCI 1.1 manifest.
+func buildDeck(ctx context.Context, deckPath, imagesDir, tag string, version manifestVersion) (*memory.Store, error) {
 	cards, err := readDeck(deckPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading deck: %w", err)
@@ -53,13 +182,15 @@ func buildDeck(ctx context.Context, deckPath, imagesDir, tag string) (*memory.St
 
 	store := memory.New()
 
-	uniqueCards := make(map[string]bool)
-	for _, c := range cards {
-		uniqueCards[c] = true
-	}
-
+	// Layers follow the first appearance of each card in the deck so the
+	// manifest digest is stable for a given deck file.
+	seen := make(map[string]bool)
 	var layers []v1.Descriptor
-	for shorthand := range uniqueCards {
+	for _, shorthand := range cards {
+		if seen[shorthand] {
+			continue
+		}
+		seen[shorthand] = true
 		filename, err := shorthandToFilename(shorthand)
 		if err != nil {
 			return nil, err
@@ -76,8 +207,8 @@ func buildDeck(ctx context.Context, deckPath, imagesDir, tag string) (*memory.St
 		}
 
 		desc.Annotations = map[string]string{
-			v1.AnnotationTitle:         filename,
-			"io.github.card-deck.card": shorthand,
+			v1.AnnotationTitle: filename,
+			cardAnnotation:     shorthand,
 		}
 
 		layers = append(layers, desc)
@@ -101,7 +232,12 @@ func buildDeck(ctx context.Context, deckPath, imagesDir, tag string) (*memory.St
 		Layers:           layers,
 		ConfigDescriptor: &configDesc,
 	}
-	manifestDesc, err := oras.PackManifest(ctx, store, oras.PackManifestVersion1_1, artifactType, packOpts)
+	packVersion, packType := oras.PackManifestVersion1_1, artifactType
+	if version == manifest1_0 {
+		// The config media type identifies the deck in place of artifactType.
+		packVersion, packType = oras.PackManifestVersion1_0, ""
+	}
+	manifestDesc, err := oras.PackManifest(ctx, store, packVersion, packType, packOpts)
 	if err != nil {
 		return nil, fmt.Errorf("packing manifest: %w", err)
 	}
@@ -114,65 +250,122 @@ func buildDeck(ctx context.Context, deckPath, imagesDir, tag string) (*memory.St
 }
 
 // pushDeck builds an OCI artifact from a deck of cards and pushes it to a registry.
-func pushDeck(ctx context.Context, target, deckPath, imagesDir string, plainHTTP bool) error {
-	tag := parseRef(target)
-
-	store, err := buildDeck(ctx, deckPath, imagesDir, tag)
+func pushDeck(ctx context.Context, target, deckPath, imagesDir string, opts registryOptions, push pushOptions) error {
+	parsed, err := parseImageReference(target)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid target reference: %w", err)
 	}
+	if parsed.Digest != "" {
+		return fmt.Errorf("invalid target reference %q: pushing needs a tag, not a digest", target)
+	}
+	if parsed.Range != "" {
+		return fmt.Errorf("invalid target reference %q: pushing needs a tag, not a version range", target)
+	}
+	tag := parsed.reference()
 
-	ref, err := remote.NewRepository(target)
+	ref, err := opts.newRepository(target)
 	if err != nil {
 		return fmt.Errorf("invalid target reference: %w", err)
 	}
-	ref.PlainHTTP = plainHTTP
-
-	credStore, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	mounts, err := mountRepositories(ref.Reference, push.mountFrom)
 	if err != nil {
-		return fmt.Errorf("loading docker credentials: %w", err)
-	}
-	ref.Client = &auth.Client{
-		Cache:      auth.NewCache(),
-		Credential: credentials.Credential(credStore),
+		return err
 	}
 
-	copyOpts := oras.CopyOptions{}
-	copyOpts.PreCopy = func(_ context.Context, desc v1.Descriptor) error {
-		if desc.MediaType == "image/png" {
-			name := desc.Annotations[v1.AnnotationTitle]
-			fmt.Printf("  uploading %s (%d bytes)\n", name, desc.Size)
+	version := push.manifestVersion
+	if version == manifestAuto {
+		version, err = probeManifestVersion(ctx, ref)
+		if err != nil {
+			return err
 		}
-		return nil
-	}
-	copyOpts.OnCopySkipped = func(_ context.Context, desc v1.Descriptor) error {
-		if desc.MediaType == "image/png" {
-			name := desc.Annotations[v1.AnnotationTitle]
-			fmt.Printf("  skipped %s (already exists)\n", name)
+		if version == manifest1_0 {
+			fmt.Println("Registry does not support OCI 1.1 artifacts, packing an OCI 1.0 manifest")
 		}
+	}
+
+	store, err := buildDeck(ctx, deckPath, imagesDir, tag, version)
+	if err != nil {
+		return err
+	}
+
+	err = checkTag(ctx, ref, store, tag, push)
+	if errors.Is(err, errTagUnchanged) {
+		fmt.Printf("\n%s already holds this deck, nothing to push\n", target)
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
 	fmt.Printf("\nPushing to %s ...\n", target)
-	_, err = oras.Copy(ctx, store, tag, ref, tag, copyOpts)
+	prog := newProgress(os.Stdout, opts.progress, "uploaded")
+	copyOpts := oras.CopyOptions{}
+	copyOpts.OnCopySkipped = func(_ context.Context, desc v1.Descriptor) error {
+		prog.skip(desc)
+		return nil
+	}
+	enableMounts(&copyOpts, mounts, prog)
+	src := newProgressSource(store, prog)
+	err = opts.withRetry(ctx, "push", prog, func() error {
+		_, err := oras.Copy(ctx, src, tag, ref, tag, copyOpts)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("copying to registry: %w", err)
 	}
+	prog.finish()
 
 	fmt.Println("Done.")
 	return nil
 }
 
-// saveDeckLocal builds an OCI artifact and writes it to a local OCI layout directory.
-func saveDeckLocal(ctx context.Context, outputDir, deckPath, imagesDir, tag string) error {
-	store, err := buildDeck(ctx, deckPath, imagesDir, tag)
+// saveDeckLocal builds an OCI artifact and writes it to a local OCI layout
+// directory or oci-archive tarball (see parseOutputRef). A tag embedded in
+// outputDir overrides tag.
+func saveDeckLocal(ctx context.Context, outputDir, deckPath, imagesDir, tag string, push pushOptions) error {
+	out, err := parseOutputRef(outputDir)
 	if err != nil {
 		return err
 	}
+	if out.Tag != "" {
+		tag = out.Tag
+	}
 
-	dst, err := oci.New(outputDir)
+	store, err := buildDeck(ctx, deckPath, imagesDir, tag, push.manifestVersion)
 	if err != nil {
-		return fmt.Errorf("creating OCI layout at %s: %w", outputDir, err)
+		return err
+	}
+
+	if out.Transport == transportArchive {
+		err := updateArchive(ctx, out.Location, false, func(dst *oci.Store) error {
+			if err := checkTag(ctx, dst, store, tag, push); err != nil {
+				return err
+			}
+			_, err := oras.Copy(ctx, store, tag, dst, tag, oras.DefaultCopyOptions)
+			return err
+		})
+		if errors.Is(err, errTagUnchanged) {
+			fmt.Printf("%s already holds this deck as %s, nothing to write\n", out.Location, tag)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("writing OCI archive %s: %w", out.Location, err)
+		}
+		fmt.Println("Done.")
+		return nil
+	}
+
+	dst, err := oci.New(out.Location)
+	if err != nil {
+		return fmt.Errorf("creating OCI layout at %s: %w", out.Location, err)
+	}
+	err = checkTag(ctx, dst, store, tag, push)
+	if errors.Is(err, errTagUnchanged) {
+		fmt.Printf("%s already holds this deck as %s, nothing to write\n", out.Location, tag)
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
 	copyOpts := oras.DefaultCopyOptions
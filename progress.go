@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+)
+
+// progressMode selects how transfer progress is displayed.
+type progressMode int
+
+const (
+	progressAuto  progressMode = iota // tty when stdout is a terminal, plain otherwise
+	progressTTY                       // single status line redrawn in place
+	progressPlain                     // one log line per blob event, for CI
+	progressNone                      // no progress output
+)
+
+func parseProgressMode(s string) (progressMode, error) {
+	switch s {
+	case "auto", "":
+		return progressAuto, nil
+	case "tty":
+		return progressTTY, nil
+	case "plain":
+		return progressPlain, nil
+	case "none":
+		return progressNone, nil
+	}
+	return 0, fmt.Errorf("invalid progress mode %q (want auto, tty, plain or none)", s)
+}
+
+func (m progressMode) String() string {
+	switch m {
+	case progressTTY:
+		return "tty"
+	case progressPlain:
+		return "plain"
+	case progressNone:
+		return "none"
+	}
+	return "auto"
+}
+
+// Set implements flag.Value.
+func (m *progressMode) Set(s string) error {
+	v, err := parseProgressMode(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// isTerminal reports whether f is attached to a character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progress tracks bytes and blobs moved during a push or pull and renders
+// them either as a redrawn status line or as plain log lines.
+type progress struct {
+	mu   sync.Mutex
+	out  io.Writer
+	mode progressMode
+	verb string // past tense shown per blob, e.g. "uploaded"
+	now  func() time.Time
+
+	start      time.Time
+	lastDraw   time.Time
+	totalBytes int64
+	totalBlobs int
+	seen       map[digest.Digest]bool
+	finished   map[digest.Digest]bool
+	inFlight   map[digest.Digest]int64
+	doneBytes  int64
+	doneBlobs  int
+	skipBytes  int64
+	skipBlobs  int
+}
+
+// newProgress returns a progress reporter writing to out. progressAuto is
+// resolved against out when it is a terminal.
+func newProgress(out io.Writer, mode progressMode, verb string) *progress {
+	if mode == progressAuto {
+		mode = progressPlain
+		if f, ok := out.(*os.File); ok && isTerminal(f) {
+			mode = progressTTY
+		}
+	}
+	return &progress{
+		out:      out,
+		mode:     mode,
+		verb:     verb,
+		now:      time.Now,
+		start:    time.Now(),
+		seen:     make(map[digest.Digest]bool),
+		finished: make(map[digest.Digest]bool),
+		inFlight: make(map[digest.Digest]int64),
+	}
+}
+
+// expectLocked adds desc to the totals once.
+func (p *progress) expectLocked(desc ocispec.Descriptor) {
+	if p.seen[desc.Digest] {
+		return
+	}
+	p.seen[desc.Digest] = true
+	p.totalBlobs++
+	p.totalBytes += desc.Size
+}
+
+// expectManifest adds the config and layers referenced by manifestBytes to
+// the totals so the ETA covers the whole deck.
+func (p *progress) expectManifest(manifestBytes []byte) {
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expectLocked(manifest.Config)
+	for _, layer := range manifest.Layers {
+		p.expectLocked(layer)
+	}
+}
+
+// begin marks desc as in flight, discarding bytes from any earlier attempt.
+// Blobs that already completed, for example before a retry, are ignored.
+func (p *progress) begin(desc ocispec.Descriptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expectLocked(desc)
+	if p.finished[desc.Digest] {
+		return
+	}
+	p.inFlight[desc.Digest] = 0
+	p.drawLocked(false)
+}
+
+// add records n more bytes transferred for desc.
+func (p *progress) add(desc ocispec.Descriptor, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.inFlight[desc.Digest]; !ok {
+		return
+	}
+	p.inFlight[desc.Digest] += n
+	p.drawLocked(false)
+}
+
+// done marks desc as fully transferred.
+func (p *progress) done(desc ocispec.Descriptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.inFlight[desc.Digest]; !ok {
+		return
+	}
+	delete(p.inFlight, desc.Digest)
+	p.finished[desc.Digest] = true
+	p.doneBytes += desc.Size
+	p.doneBlobs++
+	p.eventLocked(fmt.Sprintf("%s %s (%d bytes)", p.verb, blobName(desc), desc.Size))
+}
+
+// skip marks desc as already present at the destination.
+func (p *progress) skip(desc ocispec.Descriptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expectLocked(desc)
+	if p.finished[desc.Digest] {
+		return
+	}
+	delete(p.inFlight, desc.Digest)
+	p.finished[desc.Digest] = true
+	p.skipBytes += desc.Size
+	p.skipBlobs++
+	p.eventLocked(fmt.Sprintf("skipped %s (already exists)", blobName(desc)))
+}
+
+// mounted marks desc as linked from another repository instead of uploaded.
+func (p *progress) mounted(desc ocispec.Descriptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expectLocked(desc)
+	if p.finished[desc.Digest] {
+		return
+	}
+	delete(p.inFlight, desc.Digest)
+	p.finished[desc.Digest] = true
+	p.skipBytes += desc.Size
+	p.skipBlobs++
+	p.eventLocked(fmt.Sprintf("mounted %s (%d bytes)", blobName(desc), desc.Size))
+}
+
+// retrying reports that an operation failed and will be attempted again.
+func (p *progress) retrying(op string, attempt, max int, wait time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.eventLocked(fmt.Sprintf("retrying %s in %s (attempt %d/%d): %v", op, wait.Round(time.Millisecond), attempt, max, err))
+}
+
+// finish prints the final totals.
+func (p *progress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mode == progressNone {
+		return
+	}
+	if p.mode == progressTTY {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+	elapsed := p.now().Sub(p.start)
+	fmt.Fprintf(p.out, "  %d blobs %s (%s), %d skipped (%s) in %s\n",
+		p.doneBlobs, p.verb, formatBytes(p.doneBytes),
+		p.skipBlobs, formatBytes(p.skipBytes), elapsed.Round(time.Millisecond))
+}
+
+func (p *progress) eventLocked(msg string) {
+	switch p.mode {
+	case progressPlain:
+		fmt.Fprintf(p.out, "  %s [%s]\n", msg, p.statusLocked())
+	case progressTTY:
+		fmt.Fprintf(p.out, "\r\033[K  %s\n", msg)
+		p.drawLocked(true)
+	}
+}
+
+// drawLocked redraws the TTY status line, at most every 100ms unless forced.
+func (p *progress) drawLocked(force bool) {
+	if p.mode != progressTTY {
+		return
+	}
+	now := p.now()
+	if !force && now.Sub(p.lastDraw) < 100*time.Millisecond {
+		return
+	}
+	p.lastDraw = now
+	fmt.Fprintf(p.out, "\r\033[K  %s", p.statusLocked())
+}
+
+// statusLocked summarises totals, throughput and the estimated time left.
+func (p *progress) statusLocked() string {
+	transferred := p.doneBytes
+	for _, n := range p.inFlight {
+		transferred += n
+	}
+	status := fmt.Sprintf("%d/%d blobs, %s/%s",
+		p.doneBlobs+p.skipBlobs, p.totalBlobs,
+		formatBytes(transferred+p.skipBytes), formatBytes(p.totalBytes))
+	elapsed := p.now().Sub(p.start)
+	if transferred > 0 && elapsed > 0 {
+		rate := float64(transferred) / elapsed.Seconds()
+		status += fmt.Sprintf(", %s/s", formatBytes(int64(rate)))
+		if remaining := p.totalBytes - transferred - p.skipBytes; remaining > 0 {
+			eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+			status += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+	}
+	return status
+}
+
+// blobName returns a human-friendly name for desc.
+func blobName(desc ocispec.Descriptor) string {
+	if name := desc.Annotations[ocispec.AnnotationTitle]; name != "" {
+		return name
+	}
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest:
+		return "manifest " + desc.Digest.Encoded()[:12]
+	case configMediaType:
+		return "config " + desc.Digest.Encoded()[:12]
+	}
+	return desc.Digest.String()
+}
+
+// formatBytes renders n using binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressSource wraps a read-only target so every blob read through it is
+// reported to a progress tracker.
+type progressSource struct {
+	oras.ReadOnlyTarget
+	p *progress
+}
+
+func newProgressSource(src oras.ReadOnlyTarget, p *progress) oras.ReadOnlyTarget {
+	return &progressSource{ReadOnlyTarget: src, p: p}
+}
+
+func (s *progressSource) Fetch(ctx context.Context, target ocispec.Descriptor) (io.ReadCloser, error) {
+	rc, err := s.ReadOnlyTarget.Fetch(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	s.p.begin(target)
+	if target.MediaType != ocispec.MediaTypeImageManifest {
+		return &progressReader{rc: rc, desc: target, p: s.p}, nil
+	}
+
+	// Read manifests eagerly so their layers count towards the totals.
+	defer rc.Close()
+	data, err := content.ReadAll(rc, target)
+	if err != nil {
+		return nil, err
+	}
+	s.p.add(target, int64(len(data)))
+	s.p.done(target)
+	s.p.expectManifest(data)
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// progressGraphSource is a progressSource that can also list predecessors,
+// as oras.ExtendedCopy needs to find referrers.
+type progressGraphSource struct {
+	progressSource
+	graph oras.ReadOnlyGraphTarget
+}
+
+func newProgressGraphSource(src oras.ReadOnlyGraphTarget, p *progress) oras.ReadOnlyGraphTarget {
+	return &progressGraphSource{progressSource: progressSource{ReadOnlyTarget: src, p: p}, graph: src}
+}
+
+func (s *progressGraphSource) Predecessors(ctx context.Context, node ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	return s.graph.Predecessors(ctx, node)
+}
+
+// progressReader counts bytes as they are read and marks the blob done once
+// all of it has been read.
+type progressReader struct {
+	rc   io.ReadCloser
+	desc ocispec.Descriptor
+	p    *progress
+	read int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.rc.Read(b)
+	r.read += int64(n)
+	r.p.add(r.desc, int64(n))
+	if r.read >= r.desc.Size || err == io.EOF {
+		r.p.done(r.desc)
+	}
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/olareg/olareg"
+	"github.com/olareg/olareg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+// setupFlakyRegistry starts an in-memory olareg registry that answers the
+// first failures PUT requests with 503 Service Unavailable.
+func setupFlakyRegistry(t *testing.T, failures int32) string {
+	t.Helper()
+	regHandler := olareg.New(config.Config{
+		Storage: config.ConfigStorage{
+			StoreType: config.StoreMem,
+		},
+	})
+	var failed atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && failed.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		regHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+		regHandler.Close()
+	})
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func TestPushDeckRetriesTransientErrors(t *testing.T) {
+	deckFile := writeDeckFile(t, []string{"2c", "ad"})
+	ctx := context.Background()
+
+	addr := setupFlakyRegistry(t, 2)
+	noRetry := registryOptions{plainHTTP: true, progress: progressNone}
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deckFile, "PNG-cards-1.3", noRetry, pushOptions{}); err == nil {
+		t.Fatal("expected push without retries to fail")
+	}
+
+	addr = setupFlakyRegistry(t, 2)
+	withRetry := registryOptions{plainHTTP: true, progress: progressNone, retries: 3, retryBackoff: time.Millisecond}
+	target := fmt.Sprintf("%s/deck:v1", addr)
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", withRetry, pushOptions{}); err != nil {
+		t.Fatalf("pushDeck with retries failed: %v", err)
+	}
+
+	src, tag, err := openDeck(ctx, target, withRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := loadDeck(ctx, src, tag)
+	if err != nil {
+		t.Fatalf("loadDeck after retried push failed: %v", err)
+	}
+	if len(ds.cards) != 2 {
+		t.Errorf("got %d cards, want 2", len(ds.cards))
+	}
+}
+
+func TestPushDeckRetriesOnce(t *testing.T) {
+	regHandler := olareg.New(config.Config{Storage: config.ConfigStorage{StoreType: config.StoreMem}})
+	var puts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			puts.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		regHandler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	defer regHandler.Close()
+	u, _ := url.Parse(ts.URL)
+
+	// Retries happen per operation only, not again inside each request.
+	opts := registryOptions{plainHTTP: true, progress: progressNone, retries: 2, retryBackoff: time.Millisecond}
+	if err := pushDeck(context.Background(), u.Host+"/deck:v1", writeDeckFile(t, []string{"2c"}), "PNG-cards-1.3", opts, pushOptions{}); err == nil {
+		t.Fatal("expected push to fail")
+	}
+	// Three attempts, each uploading at most the config and the one layer.
+	if n := puts.Load(); n < 3 || n > 6 {
+		t.Errorf("sent %d PUT requests, want 3 to 6", n)
+	}
+}
+
+func TestProgressPlainOutput(t *testing.T) {
+	ctx := context.Background()
+	deckFile := writeDeckFile(t, []string{"2c", "ad"})
+	store, err := buildDeck(ctx, deckFile, "PNG-cards-1.3", "v1", manifest1_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	prog := newProgress(&out, progressPlain, "uploaded")
+	dst := memory.New()
+	if _, err := oras.Copy(ctx, newProgressSource(store, prog), "v1", dst, "v1", oras.DefaultCopyOptions); err != nil {
+		t.Fatal(err)
+	}
+	prog.finish()
+
+	got := out.String()
+	for _, want := range []string{
+		"uploaded 2_of_clubs.png (23590 bytes)",
+		"uploaded ace_of_diamonds.png (36810 bytes)",
+		"4/4 blobs",
+		"4 blobs uploaded",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("progress output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestProgressSkippedAndETA(t *testing.T) {
+	var out bytes.Buffer
+	prog := newProgress(&out, progressPlain, "uploaded")
+	start := prog.start
+	prog.now = func() time.Time { return start.Add(time.Second) }
+
+	a := ocispec.Descriptor{MediaType: "image/png", Digest: "sha256:aaaa", Size: 1000,
+		Annotations: map[string]string{ocispec.AnnotationTitle: "a.png"}}
+	b := ocispec.Descriptor{MediaType: "image/png", Digest: "sha256:bbbb", Size: 3000,
+		Annotations: map[string]string{ocispec.AnnotationTitle: "b.png"}}
+	c := ocispec.Descriptor{MediaType: "image/png", Digest: "sha256:cccc", Size: 500,
+		Annotations: map[string]string{ocispec.AnnotationTitle: "c.png"}}
+
+	prog.skip(c)
+	prog.begin(b)
+	prog.begin(a)
+	prog.add(a, 1000)
+	prog.done(a)
+
+	got := out.String()
+	if !strings.Contains(got, "skipped c.png (already exists)") {
+		t.Errorf("missing skipped line:\n%s", got)
+	}
+	// 1000 bytes/s with 3000 bytes left.
+	if !strings.Contains(got, "uploaded a.png (1000 bytes) [2/3 blobs, 1.5 KiB/4.4 KiB, 1000 B/s, ETA 3s]") {
+		t.Errorf("unexpected status line:\n%s", got)
+	}
+
+	// A blob completed before a retry is not counted twice.
+	prog.begin(a)
+	prog.add(a, 1000)
+	prog.done(a)
+	prog.skip(a)
+	if prog.doneBlobs != 1 || prog.skipBlobs != 1 {
+		t.Errorf("doneBlobs=%d skipBlobs=%d, want 1 and 1", prog.doneBlobs, prog.skipBlobs)
+	}
+}
+
+func TestProgressTTYRedrawsStatusLine(t *testing.T) {
+	var out bytes.Buffer
+	prog := newProgress(&out, progressTTY, "fetched")
+	desc := ocispec.Descriptor{MediaType: "image/png", Digest: "sha256:aaaa", Size: 10,
+		Annotations: map[string]string{ocispec.AnnotationTitle: "a.png"}}
+	prog.begin(desc)
+	prog.add(desc, 10)
+	prog.done(desc)
+	prog.finish()
+
+	got := out.String()
+	if !strings.Contains(got, "\r\033[K  fetched a.png (10 bytes)\n") {
+		t.Errorf("missing blob line:\n%q", got)
+	}
+	if !strings.Contains(got, "\r\033[K  1 blobs fetched (10 B), 0 skipped (0 B) in ") {
+		t.Errorf("missing summary:\n%q", got)
+	}
+}
+
+func TestProgressNoneIsSilent(t *testing.T) {
+	var out bytes.Buffer
+	prog := newProgress(&out, progressNone, "uploaded")
+	desc := ocispec.Descriptor{MediaType: "image/png", Digest: "sha256:aaaa", Size: 10}
+	prog.begin(desc)
+	prog.add(desc, 10)
+	prog.done(desc)
+	prog.retrying("push", 1, 3, time.Second, fmt.Errorf("boom"))
+	prog.finish()
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+}
+
+func TestParseProgressMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    progressMode
+		wantErr bool
+	}{
+		{"auto", progressAuto, false},
+		{"tty", progressTTY, false},
+		{"plain", progressPlain, false},
+		{"none", progressNone, false},
+		{"fancy", 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := parseProgressMode(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q", tc.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("parseProgressMode(%q) = %v, want %v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		input int64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+	}
+	for _, tc := range tests {
+		if got := formatBytes(tc.input); got != tc.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+// flakyMethod makes the next n requests with the given method fail with 503
+// Service Unavailable.
+type flakyMethod struct {
+	mu     sync.Mutex
+	method string
+	n      int
+}
+
+func (f *flakyMethod) arm(method string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.method, f.n = method, n
+}
+
+func (f *flakyMethod) fail(r *http.Request) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method != f.method || f.n == 0 {
+		return false
+	}
+	f.n--
+	return true
+}
+
+func TestRegistryCommandsRetry(t *testing.T) {
+	enabled := true
+	regHandler := olareg.New(config.Config{
+		Storage: config.ConfigStorage{StoreType: config.StoreMem},
+		API:     config.ConfigAPI{DeleteEnabled: &enabled},
+	})
+	var flaky flakyMethod
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flaky.fail(r) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		regHandler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	defer regHandler.Close()
+	u, _ := url.Parse(ts.URL)
+	repo := u.Host + "/deck"
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone, retries: 3, retryBackoff: time.Millisecond}
+	for _, tag := range []string{"v1", "v2"} {
+		if err := pushDeck(ctx, repo+":"+tag, writeDeckFile(t, []string{"2c"}), "PNG-cards-1.3", opts, pushOptions{skipIdentical: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flaky.arm(http.MethodGet, 2)
+	if err := lockDecks(ctx, filepath.Join(t.TempDir(), "decks.lock"), []string{repo + ":v1"}, opts); err != nil {
+		t.Errorf("lock with flaky reads failed: %v", err)
+	}
+	flaky.arm(http.MethodGet, 2)
+	if sources, err := collectDeckSources(ctx, nil, "", repo, opts); err != nil || len(sources) != 2 {
+		t.Errorf("--serve-repo with flaky reads = %v, %v; want 2 decks", sources, err)
+	}
+	flaky.arm(http.MethodDelete, 1)
+	if err := untagDeck(ctx, repo, "v2", opts); err != nil {
+		t.Errorf("untag with a flaky delete failed: %v", err)
+	}
+	flaky.arm(http.MethodDelete, 1)
+	if err := deleteDeck(ctx, repo, "v1", opts); err != nil {
+		t.Errorf("delete with a flaky delete failed: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// untagDeck removes tag from the layout or registry repository at source,
+// leaving the manifest it pointed to in place.
+func untagDeck(ctx context.Context, source, tag string, opts registryOptions) error {
+	repo, err := openRepository(ctx, source, opts)
+	if err != nil {
+		return err
+	}
+
+	switch r := repo.(type) {
+	case *oci.Store:
+		if err := r.Untag(ctx, tag); err != nil {
+			return fmt.Errorf("untagging %s: %w", tag, err)
+		}
+	case *remote.Repository:
+		prog := newProgress(os.Stdout, opts.progress, "untagged")
+		if err := opts.withRetry(ctx, "untag", prog, func() error {
+			return untagRemote(ctx, r, tag)
+		}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("untag is not supported for %s", source)
+	}
+	fmt.Printf("Untagged %s\n", tag)
+	return nil
+}
+
+// untagRemote deletes a tag through the manifests endpoint. Deleting by tag is
+// optional in the distribution spec, so registries may refuse it.
+func untagRemote(ctx context.Context, repo *remote.Repository, tag string) error {
+	scheme := "https"
+	if repo.PlainHTTP {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, repo.Reference.Host(), repo.Reference.Repository, tag)
+	ctx = auth.AppendRepositoryScope(ctx, repo.Reference, auth.ActionDelete)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := repo.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("untagging %s: %w", tag, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("untagging %s: tag not found", tag)
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		// Transient; withRetry retries these.
+		return fmt.Errorf("untagging %s: %w", tag, &errcode.ErrorResponse{Method: req.Method, URL: req.URL, StatusCode: resp.StatusCode})
+	}
+	return fmt.Errorf("untagging %s: registry does not support deleting tags (%s)", tag, resp.Status)
+}
+
+// deleteDeck deletes the manifest that ref (a tag or digest) resolves to,
+// which also drops every tag pointing at it. In layouts, blobs that are no
+// longer referenced are removed along with it.
+func deleteDeck(ctx context.Context, source, ref string, opts registryOptions) error {
+	repo, err := openRepository(ctx, source, opts)
+	if err != nil {
+		return err
+	}
+	deleter, ok := repo.(interface {
+		Delete(ctx context.Context, target ocispec.Descriptor) error
+	})
+	if !ok {
+		return fmt.Errorf("delete is not supported for %s", source)
+	}
+
+	var desc ocispec.Descriptor
+	prog := newProgress(os.Stdout, opts.progress, "deleted")
+	err = opts.withRetry(ctx, "delete", prog, func() error {
+		if desc, err = repo.Resolve(ctx, ref); err != nil {
+			return fmt.Errorf("resolving %q: %w", ref, err)
+		}
+		if err := deleter.Delete(ctx, desc); err != nil {
+			return fmt.Errorf("deleting %s: %w", desc.Digest, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %s (%s)\n", ref, desc.Digest)
+	return nil
+}
+
+// gcBlob is a blob in a layout that is not reachable from any tag.
+type gcBlob struct {
+	Digest digest.Digest
+	Size   int64
+}
+
+// unreachableBlobs lists the blobs in the layout at dir that are not
+// reachable from a tagged manifest or one of its referrers.
+func unreachableBlobs(ctx context.Context, store *oci.Store, dir string) ([]gcBlob, error) {
+	var queue []ocispec.Descriptor
+	if err := store.Tags(ctx, "", func(tags []string) error {
+		for _, tag := range tags {
+			desc, err := store.Resolve(ctx, tag)
+			if err != nil {
+				return fmt.Errorf("resolving %s: %w", tag, err)
+			}
+			queue = append(queue, desc)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[digest.Digest]bool)
+	for len(queue) > 0 {
+		desc := queue[0]
+		queue = queue[1:]
+		if reachable[desc.Digest] {
+			continue
+		}
+		reachable[desc.Digest] = true
+
+		successors, err := content.Successors(ctx, store, desc)
+		if err != nil {
+			return nil, fmt.Errorf("walking %s: %w", desc.Digest, err)
+		}
+		queue = append(queue, successors...)
+
+		if desc.MediaType == ocispec.MediaTypeImageManifest || desc.MediaType == ocispec.MediaTypeImageIndex {
+			referrers, err := registry.Referrers(ctx, store, desc, "")
+			if err != nil {
+				return nil, fmt.Errorf("listing referrers of %s: %w", desc.Digest, err)
+			}
+			queue = append(queue, referrers...)
+		}
+	}
+
+	blobs, err := layoutBlobs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var garbage []gcBlob
+	for _, b := range blobs {
+		if !reachable[b.Digest] {
+			garbage = append(garbage, b)
+		}
+	}
+	return garbage, nil
+}
+
+// layoutBlobs lists the blobs stored in the layout at dir, by digest.
+func layoutBlobs(dir string) ([]gcBlob, error) {
+	var blobs []gcBlob
+	blobsDir := filepath.Join(dir, ocispec.ImageBlobsDir)
+	algDirs, err := os.ReadDir(blobsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, algDir := range algDirs {
+		if !algDir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(blobsDir, algDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(algDir.Name()), entry.Name())
+			if dgst.Validate() != nil {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			blobs = append(blobs, gcBlob{Digest: dgst, Size: info.Size()})
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Digest < blobs[j].Digest })
+	return blobs, nil
+}
+
+// gcLayout removes blobs from the layout at dir that no tagged manifest
+// references and reports the blobs removed. With dryRun set it only reports
+// what would be.
+func gcLayout(ctx context.Context, w io.Writer, dir string, dryRun bool) ([]gcBlob, error) {
+	if _, err := os.Stat(filepath.Join(dir, ocispec.ImageLayoutFile)); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI layout: %w", dir, err)
+	}
+	store, err := oci.NewWithContext(ctx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening OCI layout %s: %w", dir, err)
+	}
+
+	garbage, err := unreachableBlobs(ctx, store, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	} else {
+		// Delete exactly what the walk found, one blob at a time, so the
+		// report matches what was removed.
+		store.AutoGC = false
+		for _, b := range garbage {
+			desc, err := store.Resolve(ctx, b.Digest.String())
+			if err != nil {
+				return nil, fmt.Errorf("resolving %s: %w", b.Digest, err)
+			}
+			if err := store.Delete(ctx, desc); err != nil {
+				return nil, fmt.Errorf("removing %s: %w", b.Digest, err)
+			}
+		}
+		// Deleting untagged manifests changes the in-memory index only.
+		if err := store.SaveIndex(); err != nil {
+			return nil, fmt.Errorf("saving index: %w", err)
+		}
+	}
+
+	var freed int64
+	for _, b := range garbage {
+		fmt.Fprintf(w, "  %s %s (%d bytes)\n", verb, b.Digest, b.Size)
+		freed += b.Size
+	}
+	if dryRun {
+		fmt.Fprintf(w, "Would free %s in %d blobs.\n", formatBytes(freed), len(garbage))
+	} else {
+		fmt.Fprintf(w, "Freed %s in %d blobs.\n", formatBytes(freed), len(garbage))
+	}
+	return garbage, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olareg/olareg"
+	"github.com/olareg/olareg/config"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// setupDeletableRegistry starts an in-memory olareg registry with the delete
+// API enabled and returns its host:port address.
+func setupDeletableRegistry(t *testing.T) string {
+	t.Helper()
+	enabled := true
+	regHandler := olareg.New(config.Config{
+		Storage: config.ConfigStorage{
+			StoreType: config.StoreMem,
+		},
+		API: config.ConfigAPI{
+			DeleteEnabled: &enabled,
+		},
+	})
+	ts := httptest.NewServer(regHandler)
+	t.Cleanup(func() {
+		ts.Close()
+		regHandler.Close()
+	})
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+// writeTwoDeckLayout saves two decks sharing the 2c card into one layout.
+func writeTwoDeckLayout(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "deck-layout")
+	deck1 := writeDeckFile(t, []string{"2c", "ad"})
+	if err := saveDeckLocal(ctx, dir, deck1, "PNG-cards-1.3", "v1", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deck2 := writeDeckFile(t, []string{"2c", "kh"})
+	if err := saveDeckLocal(ctx, dir, deck2, "PNG-cards-1.3", "v2", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs", "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestUntagAndGCLayout(t *testing.T) {
+	ctx := context.Background()
+	dir := writeTwoDeckLayout(t)
+	// 3 card images, 2 configs, 2 manifests.
+	if n := countBlobs(t, dir); n != 7 {
+		t.Fatalf("got %d blobs before gc, want 7", n)
+	}
+
+	var out bytes.Buffer
+	garbage, err := gcLayout(ctx, &out, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(garbage) != 0 {
+		t.Errorf("nothing should be garbage while both tags exist, got %v", garbage)
+	}
+
+	if err := untagDeck(ctx, dir, "v1", registryOptions{}); err != nil {
+		t.Fatalf("untag failed: %v", err)
+	}
+
+	out.Reset()
+	garbage, err = gcLayout(ctx, &out, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// v1's manifest, config and ace of diamonds image.
+	if len(garbage) != 3 {
+		t.Fatalf("dry run found %d blobs, want 3:\n%s", len(garbage), out.String())
+	}
+	if !strings.Contains(out.String(), "would remove") || !strings.Contains(out.String(), "Would free") {
+		t.Errorf("unexpected dry run output:\n%s", out.String())
+	}
+	if n := countBlobs(t, dir); n != 7 {
+		t.Fatalf("dry run removed blobs: got %d, want 7", n)
+	}
+
+	out.Reset()
+	removed, err := gcLayout(ctx, &out, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 3 || strings.Count(out.String(), "  removed ") != 3 {
+		t.Errorf("gc reported %d removed blobs, want 3:\n%s", len(removed), out.String())
+	}
+	if n := countBlobs(t, dir); n != 4 {
+		t.Fatalf("got %d blobs after gc, want 4", n)
+	}
+
+	// v2 is intact and v1 is gone, including from index.json.
+	store, err := oci.NewWithContext(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDeck(ctx, store, "v2"); err != nil {
+		t.Fatalf("loading v2 after gc failed: %v", err)
+	}
+	if _, err := store.Resolve(ctx, "v1"); err == nil {
+		t.Error("v1 still resolves after untag")
+	}
+	summaries, err := listDecks(ctx, dir, registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Tag != "v2" {
+		t.Errorf("ls after gc = %+v, want only v2", summaries)
+	}
+}
+
+func TestDeleteLayout(t *testing.T) {
+	ctx := context.Background()
+	dir := writeTwoDeckLayout(t)
+
+	if err := deleteDeck(ctx, dir, "v1", registryOptions{}); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	// Deleting also drops blobs only v1 used.
+	if n := countBlobs(t, dir); n != 4 {
+		t.Fatalf("got %d blobs after delete, want 4", n)
+	}
+
+	store, err := oci.NewWithContext(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDeck(ctx, store, "v2"); err != nil {
+		t.Fatalf("loading v2 after delete failed: %v", err)
+	}
+
+	if err := deleteDeck(ctx, dir, "v1", registryOptions{}); err == nil {
+		t.Error("expected error deleting a missing tag")
+	}
+}
+
+func TestUntagAndDeleteRegistry(t *testing.T) {
+	addr := setupDeletableRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+	repo := fmt.Sprintf("%s/deck", addr)
+
+	deck1 := writeDeckFile(t, []string{"2c", "ad"})
+	deck2 := writeDeckFile(t, []string{"2c", "kh"})
+	for tag, deckFile := range map[string]string{"v1": deck1, "v2": deck2, "v3": deck1} {
+		if err := pushDeck(ctx, repo+":"+tag, deckFile, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := untagDeck(ctx, repo, "v1", opts); err != nil {
+		t.Fatalf("untag failed: %v", err)
+	}
+	if err := deleteDeck(ctx, repo, "v2", opts); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	summaries, err := listDecks(ctx, repo, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Tag != "v3" {
+		t.Errorf("remaining tags = %+v, want only v3", summaries)
+	}
+
+	if err := untagDeck(ctx, repo, "missing", opts); err == nil {
+		t.Error("expected error untagging a missing tag")
+	}
+}
+
+func TestGCRejectsNonLayout(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := gcLayout(context.Background(), &out, filepath.Join(t.TempDir(), "nope"), true); err == nil {
+		t.Fatal("expected error for a directory without an OCI layout")
+	}
+}
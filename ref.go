@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/registry"
+)
+
+// Transports a deck reference can name with a "<transport>:" prefix.
+const (
+	transportOCI      = "oci"         // OCI layout directory
+	transportArchive  = "oci-archive" // OCI layout tarball
+	transportRegistry = "registry"    // remote registry repository
+)
+
+// defaultRegistry is used for references whose first path component is not a
+// registry host, as in "library/deck:v1".
+const defaultRegistry = "docker.io"
+
+// defaultRegistryHost serves the registry API for defaultRegistry, which
+// itself only redirects to the Docker Hub website.
+const defaultRegistryHost = "registry-1.docker.io"
+
+// tagPattern is the distribution spec's tag grammar.
+var tagPattern = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// imageReference is a validated registry reference. Tag and Digest may both
+// be set, in which case the tag is expected to point at the digest.
+type imageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Range      string // version range such as "~1.2" in place of a tag
+	Digest     digest.Digest
+}
+
+// parseImageReference parses s as [registry/]repository[:tag][@digest]. When
+// the first path component does not look like a host (no "." or ":" and not
+// "localhost") the reference is on defaultRegistry, where single-component
+// names live under "library/". As with docker, a private host without a dot
+// must be given with its port ("registry:5000/deck") to be told apart.
+func parseImageReference(s string) (imageReference, error) {
+	invalid := func(format string, args ...any) (imageReference, error) {
+		return imageReference{}, fmt.Errorf("invalid reference %q: %s", s, fmt.Sprintf(format, args...))
+	}
+	if s == "" {
+		return invalid("empty reference")
+	}
+
+	var ref imageReference
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		dgst, err := digest.Parse(name[i+1:])
+		if err != nil {
+			return invalid("bad digest %q: %v", name[i+1:], err)
+		}
+		name, ref.Digest = name[:i], dgst
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if isVersionRange(ref.Tag) {
+			if _, err := parseVersionRange(ref.Tag); err != nil {
+				return invalid("%v", err)
+			}
+			if ref.Digest != "" {
+				return invalid("version range %s cannot be pinned to a digest", ref.Tag)
+			}
+			ref.Tag, ref.Range = "", ref.Tag
+		} else if !tagPattern.MatchString(ref.Tag) {
+			return invalid("bad tag %q", ref.Tag)
+		}
+	}
+
+	first, rest, found := strings.Cut(name, "/")
+	if found && isRegistryHost(first) {
+		ref.Registry, ref.Repository = first, rest
+	} else {
+		ref.Registry, ref.Repository = defaultRegistry, name
+		if !found {
+			ref.Repository = "library/" + name
+		}
+	}
+
+	check := registry.Reference{Registry: ref.Registry, Repository: ref.Repository}
+	if err := check.ValidateRegistry(); err != nil {
+		return invalid("bad registry %q", ref.Registry)
+	}
+	if name == "" || ref.Repository == "" {
+		return invalid("missing repository")
+	}
+	if err := check.ValidateRepository(); err != nil {
+		return invalid("bad repository %q (lowercase letters, digits and separators only)", ref.Repository)
+	}
+	return ref, nil
+}
+
+// isRegistryHost reports whether the first component of a reference names a
+// registry rather than a repository namespace.
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+// registryHost returns the host serving the registry API for name.
+func registryHost(name string) string {
+	if name == defaultRegistry {
+		return defaultRegistryHost
+	}
+	return name
+}
+
+// repository returns r without its tag or digest.
+func (r imageReference) repository() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// reference returns what to resolve r with: the digest when present,
+// otherwise the tag, otherwise "latest".
+func (r imageReference) reference() string {
+	switch {
+	case r.Digest != "":
+		return r.Digest.String()
+	case r.Tag != "":
+		return r.Tag
+	}
+	return "latest"
+}
+
+// String formats r in canonical form.
+func (r imageReference) String() string {
+	s := r.repository()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Range != "" {
+		s += ":" + r.Range
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest.String()
+	}
+	return s
+}
+
+// deckRef is a parsed deck location such as "oci:decks:v2" or
+// "registry:localhost:5000/deck:v2@sha256:…".
+type deckRef struct {
+	Transport string
+	Location  string // layout directory, archive path or registry repository
+	Tag       string // empty when none was given
+	Range     string // version range such as "^1" in place of a tag
+	Digest    digest.Digest
+}
+
+// parseDeckRef parses s as "oci:<dir>[:tag][@digest]",
+// "oci-archive:<file>[:tag][@digest]" or "registry:<reference>" (see
+// parseImageReference). Without a prefix, an existing directory is a layout,
+// an existing file is an archive and anything else is a registry reference.
+func parseDeckRef(s string) (deckRef, error) {
+	transport, rest, found := strings.Cut(s, ":")
+	switch {
+	case found && (transport == transportOCI || transport == transportArchive):
+		ref, err := splitPathRef(rest)
+		if err != nil {
+			return deckRef{}, fmt.Errorf("invalid %s reference %q: %w", transport, s, err)
+		}
+		ref.Transport = transport
+		return ref, nil
+	case found && transport == transportRegistry:
+		return parseRegistryRef(rest)
+	}
+
+	if info, err := os.Stat(s); err == nil {
+		if info.IsDir() {
+			return deckRef{Transport: transportOCI, Location: s}, nil
+		}
+		return deckRef{Transport: transportArchive, Location: s}, nil
+	}
+	return parseRegistryRef(s)
+}
+
+// splitPathRef splits a trailing ":tag" and/or "@digest" off a file path.
+func splitPathRef(s string) (deckRef, error) {
+	var ref deckRef
+	if path, dgst, ok := strings.Cut(s, "@"); ok {
+		d, err := digest.Parse(dgst)
+		if err != nil {
+			return deckRef{}, fmt.Errorf("bad digest %q: %w", dgst, err)
+		}
+		s, ref.Digest = path, d
+	}
+	ref.Location = s
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		switch tag := s[i+1:]; {
+		case isVersionRange(tag):
+			if _, err := parseVersionRange(tag); err != nil {
+				return deckRef{}, err
+			}
+			if ref.Digest != "" {
+				return deckRef{}, fmt.Errorf("version range %s cannot be pinned to a digest", tag)
+			}
+			ref.Location, ref.Range = s[:i], tag
+		case tagPattern.MatchString(tag):
+			ref.Location, ref.Tag = s[:i], tag
+		}
+	}
+	if ref.Location == "" {
+		return deckRef{}, errors.New("missing path")
+	}
+	return ref, nil
+}
+
+// parseOutputRef parses s as a local destination for a new deck. Prefixed
+// references must use the oci or oci-archive transport; a bare path is an
+// archive when it ends in ".tar" or names an existing file, and a layout
+// directory otherwise.
+func parseOutputRef(s string) (deckRef, error) {
+	transport, _, found := strings.Cut(s, ":")
+	if found && (transport == transportOCI || transport == transportArchive) {
+		ref, err := parseDeckRef(s)
+		if err != nil {
+			return deckRef{}, err
+		}
+		if ref.Digest != "" || ref.Range != "" {
+			return deckRef{}, fmt.Errorf("%s: a new deck needs a tag", s)
+		}
+		return ref, nil
+	}
+	if found && transport == transportRegistry {
+		return deckRef{}, fmt.Errorf("%s is not a local destination", s)
+	}
+	if strings.HasSuffix(s, ".tar") || isArchive(s) {
+		return deckRef{Transport: transportArchive, Location: s}, nil
+	}
+	return deckRef{Transport: transportOCI, Location: s}, nil
+}
+
+func parseRegistryRef(s string) (deckRef, error) {
+	ref, err := parseImageReference(s)
+	if err != nil {
+		return deckRef{}, err
+	}
+	return deckRef{
+		Transport: transportRegistry,
+		Location:  ref.repository(),
+		Tag:       ref.Tag,
+		Range:     ref.Range,
+		Digest:    ref.Digest,
+	}, nil
+}
+
+// String formats r with its transport prefix.
+func (r deckRef) String() string {
+	return r.Transport + ":" + r.remote()
+}
+
+// remote returns r without the transport prefix.
+func (r deckRef) remote() string {
+	s := r.Location
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Range != "" {
+		s += ":" + r.Range
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest.String()
+	}
+	return s
+}
+
+// referenceOr returns what to resolve r with: the digest when present,
+// otherwise the tag, otherwise def.
+func (r deckRef) referenceOr(def string) string {
+	switch {
+	case r.Digest != "":
+		return r.Digest.String()
+	case r.Tag != "":
+		return r.Tag
+	}
+	return def
+}
+
+// verifyTag checks that the tag of r still points at its digest when r names
+// both. Loading then goes through the digest, so a tag moved after the check
+// cannot change what is read.
+func (r deckRef) verifyTag(ctx context.Context, src oras.ReadOnlyTarget) error {
+	if r.Tag == "" || r.Digest == "" {
+		return nil
+	}
+	desc, err := src.Resolve(ctx, r.Tag)
+	if err != nil {
+		return fmt.Errorf("resolving tag %q: %w", r.Tag, err)
+	}
+	if desc.Digest != r.Digest {
+		return fmt.Errorf("tag %q points to %s, not %s", r.Tag, desc.Digest, r.Digest)
+	}
+	return nil
+}
+
+// openLayout opens the existing OCI layout directory dir.
+func openLayout(ctx context.Context, dir string) (*oci.Store, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening OCI layout %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("opening OCI layout %s: not a directory", dir)
+	}
+	store, err := oci.NewWithContext(ctx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening OCI layout %s: %w", dir, err)
+	}
+	return store, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDeckRef(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "deck.tar")
+	if err := os.WriteFile(archive, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const dgst = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	tests := []struct {
+		in      string
+		want    deckRef
+		wantErr bool
+	}{
+		{"oci:decks", deckRef{transportOCI, "decks", "", "", ""}, false},
+		{"oci:decks:v2", deckRef{transportOCI, "decks", "v2", "", ""}, false},
+		{"oci:./a/decks:1.0.0", deckRef{transportOCI, "./a/decks", "1.0.0", "", ""}, false},
+		{"oci:decks@" + dgst, deckRef{transportOCI, "decks", "", "", dgst}, false},
+		{"oci-archive:deck.tar:v1", deckRef{transportArchive, "deck.tar", "v1", "", ""}, false},
+		{"oci-archive:deck.tar", deckRef{transportArchive, "deck.tar", "", "", ""}, false},
+		{"registry:localhost:5000/deck:v1", deckRef{transportRegistry, "localhost:5000/deck", "v1", "", ""}, false},
+		{"registry:localhost:5000/deck", deckRef{transportRegistry, "localhost:5000/deck", "", "", ""}, false},
+		{"registry:localhost:5000/deck@" + dgst, deckRef{transportRegistry, "localhost:5000/deck", "", "", dgst}, false},
+		{"localhost:5000/deck:v1", deckRef{transportRegistry, "localhost:5000/deck", "v1", "", ""}, false},
+		{dir, deckRef{transportOCI, dir, "", "", ""}, false},
+		{archive, deckRef{transportArchive, archive, "", "", ""}, false},
+		{"oci:decks:v2@" + dgst, deckRef{transportOCI, "decks", "v2", "", dgst}, false},
+		{"registry:localhost:5000/deck:v2@" + dgst, deckRef{transportRegistry, "localhost:5000/deck", "v2", "", dgst}, false},
+		{"registry:deck:v1", deckRef{transportRegistry, "docker.io/library/deck", "v1", "", ""}, false},
+		{"oci:decks:~1.2", deckRef{transportOCI, "decks", "", "~1.2", ""}, false},
+		{"registry:localhost:5000/deck:^1", deckRef{transportRegistry, "localhost:5000/deck", "", "^1", ""}, false},
+		{"registry:localhost:5000/deck:^1@" + dgst, deckRef{}, true},
+		{"registry:localhost:5000/deck:~x", deckRef{}, true},
+		{"oci:decks@sha256:nothex", deckRef{}, true},
+		{"oci::v1", deckRef{}, true},
+		{"registry:not a ref", deckRef{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseDeckRef(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseDeckRef(%q) = %+v, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDeckRef(%q) failed: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseDeckRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			// Prefixed forms round-trip.
+			if again, err := parseDeckRef(got.String()); err != nil || again != got {
+				t.Errorf("parseDeckRef(%q) = %+v, %v; want %+v", got.String(), again, err, got)
+			}
+		})
+	}
+}
+
+func TestParseOutputRef(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    deckRef
+		wantErr bool
+	}{
+		{"decks", deckRef{transportOCI, "decks", "", "", ""}, false},
+		{"decks.tar", deckRef{transportArchive, "decks.tar", "", "", ""}, false},
+		{"oci:decks:v2", deckRef{transportOCI, "decks", "v2", "", ""}, false},
+		{"oci-archive:decks.bundle:v2", deckRef{transportArchive, "decks.bundle", "v2", "", ""}, false},
+		{"registry:localhost:5000/deck:v1", deckRef{}, true},
+		{"oci:decks@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", deckRef{}, true},
+		{"oci:decks:^1", deckRef{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseOutputRef(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseOutputRef(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseOutputRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOpenDeckLayoutTagAndDigest(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "decks")
+	deckV1 := writeDeckFile(t, []string{"2c"})
+	deckV2 := writeDeckFile(t, []string{"ad", "kh"})
+	if err := saveDeckLocal(ctx, dir, deckV1, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Equivalent to --local oci:dir:v2.
+	if err := saveDeckLocal(ctx, "oci:"+dir+":v2", deckV2, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	src, tag, err := openDeck(ctx, "oci:"+dir+":v2", registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v2" {
+		t.Fatalf("tag = %q, want v2", tag)
+	}
+	ds, err := loadDeck(ctx, src, tag)
+	if err != nil {
+		t.Fatalf("loadDeck(v2) failed: %v", err)
+	}
+	if len(ds.cards) != 2 {
+		t.Errorf("v2 has %d cards, want 2", len(ds.cards))
+	}
+
+	desc, err := src.Resolve(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, tag, err = openDeck(ctx, fmt.Sprintf("oci:%s@%s", dir, desc.Digest), registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err = loadDeck(ctx, src, tag)
+	if err != nil {
+		t.Fatalf("loadDeck by digest failed: %v", err)
+	}
+	if len(ds.cards) != 1 || ds.cards[0] != "2c" {
+		t.Errorf("deck by digest = %v, want [2c]", ds.cards)
+	}
+
+	if _, _, err := openDeck(ctx, "oci:"+filepath.Join(t.TempDir(), "missing"), registryOptions{}); err == nil {
+		t.Error("expected error opening a missing layout")
+	}
+}
+
+func TestTransportsAcrossCommands(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	deck := writeDeckFile(t, []string{"2c", "ad"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deck, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := t.TempDir()
+	archive := filepath.Join(tmp, "decks.bundle")
+	if err := copyDeck(ctx, fmt.Sprintf("registry:%s/deck:v1", addr), "oci-archive:"+archive+":stable", opts, pushOptions{}); err != nil {
+		t.Fatalf("copy to archive failed: %v", err)
+	}
+	layout := filepath.Join(tmp, "layout")
+	if err := copyDeck(ctx, "oci-archive:"+archive+":stable", "oci:"+layout+":v1", opts, pushOptions{}); err != nil {
+		t.Fatalf("copy archive to layout failed: %v", err)
+	}
+
+	d, err := diffDecks(ctx, fmt.Sprintf("registry:%s/deck:v1", addr), "oci:"+layout+":v1", opts)
+	if err != nil {
+		t.Fatalf("diff across transports failed: %v", err)
+	}
+	if !d.Identical() {
+		t.Errorf("copied deck differs: %+v", d)
+	}
+
+	summaries, err := listDecks(ctx, "oci:"+layout, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Tag != "v1" {
+		t.Errorf("layout summaries = %+v", summaries)
+	}
+	if err := untagDeck(ctx, "oci:"+layout, "v1", opts); err != nil {
+		t.Fatalf("untag in layout failed: %v", err)
+	}
+	if err := run([]string{"gc", "oci:" + layout}); err != nil {
+		t.Fatalf("gc with oci: prefix failed: %v", err)
+	}
+	if got := countBlobs(t, layout); got != 0 {
+		t.Errorf("%d blobs left after untag and gc, want 0", got)
+	}
+}
+
+func TestTagAndDigestVerification(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	deckV1 := writeDeckFile(t, []string{"2c"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deckV1, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := opts.newRepository(fmt.Sprintf("%s/deck", addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := repo.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := fmt.Sprintf("%s/deck:v1@%s", addr, v1.Digest)
+	src, ref, err := openDeck(ctx, pinned, opts)
+	if err != nil {
+		t.Fatalf("openDeck(%s) failed: %v", pinned, err)
+	}
+	if ref != v1.Digest.String() {
+		t.Errorf("ref = %q, want digest %s", ref, v1.Digest)
+	}
+	if _, err := loadDeck(ctx, src, ref); err != nil {
+		t.Fatalf("loadDeck by pinned reference failed: %v", err)
+	}
+
+	// Copying a pinned reference keeps the tag name.
+	layout := filepath.Join(t.TempDir(), "layout")
+	if err := copyDeck(ctx, pinned, "oci:"+layout, opts, pushOptions{}); err != nil {
+		t.Fatalf("copyDeck(%s) failed: %v", pinned, err)
+	}
+	if _, _, err := openDeck(ctx, fmt.Sprintf("oci:%s:v1@%s", layout, v1.Digest), opts); err != nil {
+		t.Errorf("pinned layout reference failed: %v", err)
+	}
+
+	// Moving the tag breaks the pin.
+	deckV2 := writeDeckFile(t, []string{"ad"})
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:v1", addr), deckV2, "PNG-cards-1.3", opts, pushOptions{force: true}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = openDeck(ctx, pinned, opts)
+	if err == nil || !strings.Contains(err.Error(), "points to") {
+		t.Errorf("openDeck after retag error = %v, want tag mismatch", err)
+	}
+
+	if err := pushDeck(ctx, pinned, deckV2, "PNG-cards-1.3", opts, pushOptions{}); err == nil {
+		t.Error("expected pushing to a digest reference to fail")
+	}
+}
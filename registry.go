@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// registryOptions holds the connection and authentication settings shared by
+// every code path that talks to a remote registry.
+type registryOptions struct {
+	plainHTTP      bool
+	username       string
+	password       string
+	passwordStdin  bool
+	registryConfig string        // path to a docker-style config.json; empty uses the docker default
+	caFile         string        // PEM bundle trusted in addition to the system roots
+	certFile       string        // client certificate for mutual TLS
+	keyFile        string        // private key for certFile
+	insecure       bool          // skip TLS certificate verification
+	retries        int           // retries per registry operation; 0 disables retrying
+	retryBackoff   time.Duration // initial backoff, doubled on each attempt
+	retryMaxWait   time.Duration // upper bound on a single backoff
+	progress       progressMode
+	lock           *lockfile // when set, openDeck only opens references pinned in it
+	quiet          bool      // openDeck does not report the tag a version range resolved to
+}
+
+const (
+	defaultRetries      = 5
+	defaultRetryBackoff = 250 * time.Millisecond
+	defaultRetryMaxWait = 3 * time.Second
+)
+
+// readPassword reads a password from r, trimming the trailing newline.
+func (o *registryOptions) readPassword(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading password from stdin: %w", err)
+	}
+	o.password = strings.TrimRight(string(data), "\r\n")
+	if o.password == "" {
+		return fmt.Errorf("password from stdin is empty")
+	}
+	return nil
+}
+
+// credentialStore opens the credential store selected by --registry-config,
+// falling back to the default docker config.
+func (o registryOptions) credentialStore() (credentials.Store, error) {
+	if o.registryConfig != "" {
+		store, err := credentials.NewStore(o.registryConfig, credentials.StoreOptions{AllowPlaintextPut: true})
+		if err != nil {
+			return nil, fmt.Errorf("loading registry config %s: %w", o.registryConfig, err)
+		}
+		return store, nil
+	}
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{
+		AllowPlaintextPut:        true,
+		DetectDefaultNativeStore: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading docker credentials: %w", err)
+	}
+	return store, nil
+}
+
+// tlsConfig builds the TLS configuration for registry connections from the
+// CA, client certificate and verification options.
+func (o registryOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.insecure}
+	if o.caFile != "" {
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", o.caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (o.certFile == "") != (o.keyFile == "") {
+		return nil, fmt.Errorf("--cert-file and --key-file must be given together")
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// httpClient returns the HTTP client used underneath the auth client, with
+// the TLS settings applied. It does not retry; withRetry does.
+func (o registryOptions) httpClient() (*http.Client, error) {
+	tlsCfg, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return &http.Client{Transport: transport}, nil
+}
+
+// retryPolicy returns the exponential backoff policy withRetry waits by.
+func (o registryOptions) retryPolicy() *retry.GenericPolicy {
+	backoff, maxWait := o.retryBackoff, o.retryMaxWait
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	if maxWait <= 0 {
+		maxWait = defaultRetryMaxWait
+	}
+	return &retry.GenericPolicy{
+		Retryable: retry.DefaultPredicate,
+		Backoff:   retry.ExponentialBackoff(backoff, 2, 0.1),
+		MinWait:   backoff,
+		MaxWait:   maxWait,
+		MaxRetry:  o.retries,
+	}
+}
+
+// isRetryable reports whether err looks like a transient registry failure.
+func isRetryable(err error) bool {
+	var errResp *errcode.ErrorResponse
+	if errors.As(err, &errResp) {
+		switch {
+		case errResp.StatusCode >= 500,
+			errResp.StatusCode == http.StatusTooManyRequests,
+			errResp.StatusCode == http.StatusRequestTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// withRetry runs fn, retrying transient failures with exponential backoff.
+// It is the only place registry calls are retried: request bodies such as
+// blob uploads cannot be replayed by a transport, so whole operations are
+// retried instead; oras.Copy skips blobs that already made it across, so a
+// retried push resumes where it failed.
+func (o registryOptions) withRetry(ctx context.Context, op string, p *progress, fn func() error) error {
+	policy := o.retryPolicy()
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= o.retries || !isRetryable(err) {
+			return err
+		}
+		wait := policy.Backoff(attempt, nil)
+		wait = min(max(wait, policy.MinWait), policy.MaxWait)
+		p.retrying(op, attempt+1, o.retries, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryRead is withRetry for commands that only read, reporting retries on
+// stderr so their output stays parseable.
+func (o registryOptions) retryRead(ctx context.Context, op string, fn func() error) error {
+	return o.withRetry(ctx, op, newProgress(os.Stderr, o.progress, "fetched"), fn)
+}
+
+// authClient returns a client that uses explicit credentials for host when a
+// username was given and the credential store for everything else.
+func (o registryOptions) authClient(host string) (*auth.Client, error) {
+	store, err := o.credentialStore()
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := o.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	credFunc := credentials.Credential(store)
+	if o.username != "" {
+		if o.password == "" {
+			return nil, fmt.Errorf("no password given for %s", o.username)
+		}
+		static := auth.StaticCredential(host, auth.Credential{
+			Username: o.username,
+			Password: o.password,
+		})
+		fromStore := credFunc
+		credFunc = func(ctx context.Context, hostport string) (auth.Credential, error) {
+			if hostport == host {
+				return static(ctx, hostport)
+			}
+			return fromStore(ctx, hostport)
+		}
+	}
+	return &auth.Client{
+		Client:     httpClient,
+		Cache:      auth.NewCache(),
+		Credential: credFunc,
+	}, nil
+}
+
+// newRepository returns a remote repository for ref configured with the
+// transport and credentials from o. Any tag or digest in ref is validated but
+// not kept; callers resolve it themselves.
+func (o registryOptions) newRepository(ref string) (*remote.Repository, error) {
+	parsed, err := parseImageReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := remote.NewRepository(parsed.repository())
+	if err != nil {
+		return nil, err
+	}
+	repo.Reference.Registry = registryHost(repo.Reference.Registry)
+	repo.PlainHTTP = o.plainHTTP
+	client, err := o.authClient(repo.Reference.Registry)
+	if err != nil {
+		return nil, err
+	}
+	repo.Client = client
+	return repo, nil
+}
+
+// newRegistry returns a remote registry client for host configured with the
+// transport and credentials from o.
+func (o registryOptions) newRegistry(host string) (*remote.Registry, error) {
+	reg, err := remote.NewRegistry(registryHost(host))
+	if err != nil {
+		return nil, err
+	}
+	reg.PlainHTTP = o.plainHTTP
+	client, err := o.authClient(reg.Reference.Registry)
+	if err != nil {
+		return nil, err
+	}
+	reg.Client = client
+	return reg, nil
+}
+
+// mountRepository returns the repository path to mount blobs from for a push
+// to target. from may be a bare repository path ("team-a/deck") or a full
+// reference, which must then be on the same registry as target.
+func mountRepository(target registry.Reference, from string) (string, error) {
+	host, _, found := strings.Cut(from, "/")
+	if !found || !isRegistryHost(host) {
+		ref := registry.Reference{Registry: target.Registry, Repository: from}
+		if err := ref.ValidateRepository(); err != nil {
+			return "", fmt.Errorf("invalid mount source %q: %w", from, err)
+		}
+		return from, nil
+	}
+	ref, err := parseImageReference(from)
+	if err != nil {
+		return "", fmt.Errorf("invalid mount source: %w", err)
+	}
+	if registryHost(ref.Registry) != target.Registry {
+		return "", fmt.Errorf("mount source %q is not on registry %s", from, target.Registry)
+	}
+	return ref.Repository, nil
+}
+
+// mountRepositories resolves each of froms with mountRepository.
+func mountRepositories(target registry.Reference, froms []string) ([]string, error) {
+	var repos []string
+	for _, from := range froms {
+		repo, err := mountRepository(target, from)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// enableMounts configures copyOpts to try cross-repository mounts from each of
+// repos, in order, before uploading a blob.
+func enableMounts(copyOpts *oras.CopyOptions, repos []string, p *progress) {
+	if len(repos) == 0 {
+		return
+	}
+	copyOpts.MountFrom = func(context.Context, ocispec.Descriptor) ([]string, error) {
+		return repos, nil
+	}
+	copyOpts.OnMounted = func(_ context.Context, desc ocispec.Descriptor) error {
+		p.mounted(desc)
+		return nil
+	}
+}
+
+// loginRegistry verifies the credentials in o against host and saves them to
+// the credential store. They are stored under the name docker uses for host
+// ("https://index.docker.io/v1/" for docker.io), not under the API host
+// newRegistry talks to, so lookups and logout find them.
+func loginRegistry(ctx context.Context, host string, opts registryOptions) error {
+	if opts.username == "" {
+		return fmt.Errorf("--username is required")
+	}
+	if opts.password == "" {
+		return fmt.Errorf("--password-stdin is required")
+	}
+	store, err := opts.credentialStore()
+	if err != nil {
+		return err
+	}
+	reg, err := opts.newRegistry(host)
+	if err != nil {
+		return fmt.Errorf("invalid registry %q: %w", host, err)
+	}
+	// reg authenticates with the credentials in opts.
+	if err := reg.Ping(ctx); err != nil {
+		return fmt.Errorf("validating credentials for %s: %w", host, err)
+	}
+	key := credentials.ServerAddressFromRegistry(host)
+	cred := auth.Credential{Username: opts.username, Password: opts.password}
+	if err := store.Put(ctx, key, cred); err != nil {
+		return fmt.Errorf("storing credentials for %s: %w", host, err)
+	}
+	fmt.Printf("Login succeeded for %s\n", host)
+	return nil
+}
+
+// logoutRegistry removes the stored credentials for host.
+func logoutRegistry(ctx context.Context, host string, opts registryOptions) error {
+	store, err := opts.credentialStore()
+	if err != nil {
+		return err
+	}
+	if err := credentials.Logout(ctx, store, host); err != nil {
+		return err
+	}
+	fmt.Printf("Removed credentials for %s\n", host)
+	return nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olareg/olareg"
+	"github.com/olareg/olareg/config"
+)
+
+// setupAuthRegistry starts an in-memory olareg registry behind HTTP basic auth
+// and returns its host:port address.
+func setupAuthRegistry(t *testing.T, username, password string) string {
+	t.Helper()
+	regHandler := olareg.New(config.Config{
+		Storage: config.ConfigStorage{
+			StoreType: config.StoreMem,
+		},
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		regHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+		regHandler.Close()
+	})
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func TestPushDeckWithCredentials(t *testing.T) {
+	addr := setupAuthRegistry(t, "alice", "s3cret")
+	deckFile := writeDeckFile(t, []string{"2c"})
+	target := fmt.Sprintf("%s/deck:v1", addr)
+	ctx := context.Background()
+
+	emptyConfig := filepath.Join(t.TempDir(), "config.json")
+	noAuth := registryOptions{plainHTTP: true, registryConfig: emptyConfig}
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", noAuth, pushOptions{}); err == nil {
+		t.Fatal("expected push without credentials to fail")
+	}
+
+	withAuth := registryOptions{plainHTTP: true, registryConfig: emptyConfig, username: "alice", password: "s3cret"}
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", withAuth, pushOptions{}); err != nil {
+		t.Fatalf("pushDeck with credentials failed: %v", err)
+	}
+
+	src, tag, err := openDeck(ctx, target, withAuth)
+	if err != nil {
+		t.Fatalf("openDeck failed: %v", err)
+	}
+	if _, err := loadDeck(ctx, src, tag); err != nil {
+		t.Fatalf("loadDeck with credentials failed: %v", err)
+	}
+}
+
+func TestLoginLogout(t *testing.T) {
+	addr := setupAuthRegistry(t, "alice", "s3cret")
+	deckFile := writeDeckFile(t, []string{"2c"})
+	target := fmt.Sprintf("%s/deck:v1", addr)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	ctx := context.Background()
+
+	bad := registryOptions{plainHTTP: true, registryConfig: configPath, username: "alice", password: "wrong"}
+	if err := loginRegistry(ctx, addr, bad); err == nil {
+		t.Fatal("expected login with wrong password to fail")
+	}
+
+	good := registryOptions{plainHTTP: true, registryConfig: configPath, username: "alice", password: "s3cret"}
+	if err := loginRegistry(ctx, addr, good); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("registry config not written: %v", err)
+	}
+	if !strings.Contains(string(data), addr) {
+		t.Errorf("registry config does not mention %s: %s", addr, data)
+	}
+
+	// Subsequent operations pick up the stored credentials.
+	stored := registryOptions{plainHTTP: true, registryConfig: configPath}
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", stored, pushOptions{}); err != nil {
+		t.Fatalf("pushDeck with stored credentials failed: %v", err)
+	}
+
+	if err := logoutRegistry(ctx, addr, stored); err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", stored, pushOptions{}); err == nil {
+		t.Fatal("expected push after logout to fail")
+	}
+}
+
+func TestLoginLogoutDockerHub(t *testing.T) {
+	addr := setupAuthRegistry(t, "alice", "s3cret")
+	// Send registry-1.docker.io to the test registry.
+	defaultTransport := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+	http.DefaultTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	ctx := context.Background()
+
+	good := registryOptions{plainHTTP: true, registryConfig: configPath, username: "alice", password: "s3cret"}
+	if err := loginRegistry(ctx, defaultRegistry, good); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	stored := registryOptions{plainHTTP: true, registryConfig: configPath}
+	client, err := stored.authClient(defaultRegistryHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := client.Credential(ctx, defaultRegistryHost)
+	if err != nil || cred.Username != "alice" || cred.Password != "s3cret" {
+		t.Fatalf("credential for %s = %+v, %v; want alice's", defaultRegistryHost, cred, err)
+	}
+
+	if err := logoutRegistry(ctx, defaultRegistry, stored); err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	if client, err = stored.authClient(defaultRegistryHost); err != nil {
+		t.Fatal(err)
+	}
+	if cred, err := client.Credential(ctx, defaultRegistryHost); err != nil || cred.Username != "" {
+		t.Errorf("credential after logout = %+v, %v; want none", cred, err)
+	}
+}
+
+func TestLoginRequiresCredentials(t *testing.T) {
+	opts := registryOptions{registryConfig: filepath.Join(t.TempDir(), "config.json")}
+	if err := loginRegistry(context.Background(), "localhost:5000", opts); err == nil {
+		t.Fatal("expected error without username")
+	}
+	opts.username = "alice"
+	if err := loginRegistry(context.Background(), "localhost:5000", opts); err == nil {
+		t.Fatal("expected error without password")
+	}
+}
+
+func TestRegistryFlagsRequireUsernameAndPassword(t *testing.T) {
+	for _, args := range [][]string{{"--password-stdin"}, {"--username=alice"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts := addRegistryFlags(fs)
+		if err := parseRegistryFlags(fs, args, opts); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+	opts := registryOptions{registryConfig: filepath.Join(t.TempDir(), "config.json"), username: "alice"}
+	if _, err := opts.authClient("localhost:5000"); err == nil {
+		t.Error("expected an error for a username without a password")
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	var opts registryOptions
+	if err := opts.readPassword(strings.NewReader("s3cret\n")); err != nil {
+		t.Fatal(err)
+	}
+	if opts.password != "s3cret" {
+		t.Errorf("password = %q, want s3cret", opts.password)
+	}
+	if err := opts.readPassword(strings.NewReader("\n")); err == nil {
+		t.Error("expected error for empty password")
+	}
+}
+
+// setupTLSRegistry starts an in-memory olareg registry over HTTPS, optionally
+// requiring client certificates signed by clientCAs. It returns the registry
+// address and the path to a PEM file containing the server certificate.
+func setupTLSRegistry(t *testing.T, clientCAs *x509.CertPool) (string, string) {
+	t.Helper()
+	regHandler := olareg.New(config.Config{
+		Storage: config.ConfigStorage{
+			StoreType: config.StoreMem,
+		},
+	})
+	ts := httptest.NewUnstartedServer(regHandler)
+	if clientCAs != nil {
+		ts.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		}
+	}
+	ts.StartTLS()
+	t.Cleanup(func() {
+		ts.Close()
+		regHandler.Close()
+	})
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return u.Host, caFile
+}
+
+// writeClientCert generates a self-signed client certificate and returns the
+// certificate, its PEM path and the PEM path of its private key.
+func writeClientCert(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "card-oci-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+func TestPushDeckTLS(t *testing.T) {
+	addr, caFile := setupTLSRegistry(t, nil)
+	deckFile := writeDeckFile(t, []string{"2c"})
+	target := fmt.Sprintf("%s/deck:v1", addr)
+	ctx := context.Background()
+
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", registryOptions{}, pushOptions{}); err == nil {
+		t.Fatal("expected push to an untrusted certificate to fail")
+	}
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", registryOptions{caFile: caFile}, pushOptions{}); err != nil {
+		t.Fatalf("pushDeck with --ca-file failed: %v", err)
+	}
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", registryOptions{insecure: true}, pushOptions{skipIdentical: true}); err != nil {
+		t.Fatalf("pushDeck with --insecure failed: %v", err)
+	}
+
+	src, tag, err := openDeck(ctx, target, registryOptions{caFile: caFile})
+	if err != nil {
+		t.Fatalf("openDeck failed: %v", err)
+	}
+	if _, err := loadDeck(ctx, src, tag); err != nil {
+		t.Fatalf("loadDeck over TLS failed: %v", err)
+	}
+}
+
+func TestPushDeckMutualTLS(t *testing.T) {
+	clientCert, certFile, keyFile := writeClientCert(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	addr, caFile := setupTLSRegistry(t, pool)
+	deckFile := writeDeckFile(t, []string{"2c"})
+	target := fmt.Sprintf("%s/deck:v1", addr)
+	ctx := context.Background()
+
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", registryOptions{caFile: caFile}, pushOptions{}); err == nil {
+		t.Fatal("expected push without a client certificate to fail")
+	}
+	opts := registryOptions{caFile: caFile, certFile: certFile, keyFile: keyFile}
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatalf("pushDeck with client certificate failed: %v", err)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts registryOptions
+	}{
+		{"missing CA file", registryOptions{caFile: "/nonexistent/ca.pem"}},
+		{"cert without key", registryOptions{certFile: "client.pem"}},
+		{"key without cert", registryOptions{keyFile: "client-key.pem"}},
+		{"missing cert files", registryOptions{certFile: "/nonexistent/c.pem", keyFile: "/nonexistent/k.pem"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.opts.tlsConfig(); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	badCA := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(badCA, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (registryOptions{caFile: badCA}).tlsConfig(); err == nil {
+		t.Fatal("expected error for CA file without certificates")
+	}
+}
+
+func TestDefaultRegistryHost(t *testing.T) {
+	opts := registryOptions{registryConfig: filepath.Join(t.TempDir(), "config.json")}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"user/deck:v1", "registry-1.docker.io"},
+		{"deck", "registry-1.docker.io"},
+		{"docker.io/user/deck:v1", "registry-1.docker.io"},
+		{"ghcr.io/user/deck:v1", "ghcr.io"},
+		{"localhost:5000/deck", "localhost:5000"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			repo, err := opts.newRepository(tc.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repo.Reference.Registry != tc.want {
+				t.Errorf("newRepository(%q) host = %q, want %q", tc.ref, repo.Reference.Registry, tc.want)
+			}
+		})
+	}
+
+	reg, err := opts.newRegistry(defaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.Reference.Registry != "registry-1.docker.io" {
+		t.Errorf("newRegistry(%q) host = %q, want registry-1.docker.io", defaultRegistry, reg.Reference.Registry)
+	}
+}
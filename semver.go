@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry"
+)
+
+// semver is a semantic version parsed from a tag such as "1.2.3", "v1.2" or
+// "2.0.0-rc.1". Missing minor and patch numbers are zero.
+type semver struct {
+	major, minor, patch int
+	pre                 []string // prerelease identifiers, nil for releases
+}
+
+// parseSemver parses tag as a version with one to three numeric parts and an
+// optional "v" prefix and "-prerelease" suffix.
+func parseSemver(tag string) (semver, bool) {
+	s := strings.TrimPrefix(tag, "v")
+	var v semver
+	if core, pre, ok := strings.Cut(s, "-"); ok {
+		if pre == "" {
+			return semver{}, false
+		}
+		s, v.pre = core, strings.Split(pre, ".")
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return semver{}, false
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') {
+			return semver{}, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+// compare orders versions by precedence: numeric parts first, then a
+// release above any of its prereleases, then prerelease identifiers.
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	switch {
+	case v.pre == nil && o.pre == nil:
+		return 0
+	case v.pre == nil:
+		return 1
+	case o.pre == nil:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, aErr := strconv.Atoi(v.pre[i])
+		b, bErr := strconv.Atoi(o.pre[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if a != b {
+				return sign(a - b)
+			}
+		case aErr == nil:
+			return -1 // numeric identifiers sort before alphanumeric ones
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(v.pre[i], o.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return sign(len(v.pre) - len(o.pre))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// versionRange is a "~" or "^" constraint: versions at or above min and below
+// max. Prereleases never match.
+type versionRange struct {
+	min, max semver
+}
+
+// isVersionRange reports whether s is written as a version constraint.
+func isVersionRange(s string) bool {
+	return strings.HasPrefix(s, "~") || strings.HasPrefix(s, "^")
+}
+
+// parseVersionRange parses "~1.2" (patch updates: >=1.2.0 <1.3.0; "~1" allows
+// minor updates) or "^1.2" (updates that do not change the leftmost non-zero
+// part: >=1.2.0 <2.0.0, and "^0.2" is >=0.2.0 <0.3.0).
+func parseVersionRange(s string) (versionRange, error) {
+	if !isVersionRange(s) {
+		return versionRange{}, fmt.Errorf("invalid version range %q: want ~ or ^ prefix", s)
+	}
+	op, ver := s[0], s[1:]
+	min, ok := parseSemver(ver)
+	if !ok || min.pre != nil || strings.HasPrefix(ver, "v") {
+		return versionRange{}, fmt.Errorf("invalid version range %q", s)
+	}
+	parts := strings.Count(ver, ".") + 1
+
+	r := versionRange{min: min}
+	switch {
+	case op == '~' && parts == 1:
+		r.max = semver{major: min.major + 1}
+	case op == '~':
+		r.max = semver{major: min.major, minor: min.minor + 1}
+	case min.major > 0 || parts == 1:
+		r.max = semver{major: min.major + 1}
+	case min.minor > 0 || parts == 2:
+		r.max = semver{minor: min.minor + 1}
+	default:
+		r.max = semver{patch: min.patch + 1}
+	}
+	return r, nil
+}
+
+func (r versionRange) contains(v semver) bool {
+	return v.pre == nil && v.compare(r.min) >= 0 && v.compare(r.max) < 0
+}
+
+// highestMatch returns the tag with the highest version within r. Tags that
+// are not versions are ignored.
+func (r versionRange) highestMatch(tags []string) (string, bool) {
+	var best string
+	var bestVer semver
+	for _, tag := range tags {
+		v, ok := parseSemver(tag)
+		if !ok || !r.contains(v) {
+			continue
+		}
+		if best == "" || v.compare(bestVer) > 0 {
+			best, bestVer = tag, v
+		}
+	}
+	return best, best != ""
+}
+
+// resolveVersionRange lists the tags of src and returns the highest one within
+// the range expr.
+func resolveVersionRange(ctx context.Context, src oras.ReadOnlyTarget, expr string) (string, error) {
+	r, err := parseVersionRange(expr)
+	if err != nil {
+		return "", err
+	}
+	lister, ok := src.(registry.TagLister)
+	if !ok {
+		return "", fmt.Errorf("version range %s: source cannot list tags", expr)
+	}
+	var tags []string
+	if err := lister.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("listing tags: %w", err)
+	}
+	tag, ok := r.highestMatch(tags)
+	if !ok {
+		return "", fmt.Errorf("no tag matches version range %s", expr)
+	}
+	return tag, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		in   string
+		want semver
+		ok   bool
+	}{
+		{"1.2.3", semver{1, 2, 3, nil}, true},
+		{"v1.2", semver{1, 2, 0, nil}, true},
+		{"2", semver{2, 0, 0, nil}, true},
+		{"2.0.0-rc.1", semver{2, 0, 0, []string{"rc", "1"}}, true},
+		{"latest", semver{}, false},
+		{"1.02", semver{}, false},
+		{"1.2.3.4", semver{}, false},
+		{"1.2-", semver{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSemver(tt.in)
+		if ok != tt.ok || (ok && got.compare(tt.want) != 0) {
+			t.Errorf("parseSemver(%q) = %+v, %v; want %+v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Precedence, lowest first.
+	order := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.0.1", "1.10.0", "2.0.0"}
+	for i := 1; i < len(order); i++ {
+		a, _ := parseSemver(order[i-1])
+		b, _ := parseSemver(order[i])
+		if a.compare(b) >= 0 || b.compare(a) <= 0 {
+			t.Errorf("want %s < %s", order[i-1], order[i])
+		}
+	}
+}
+
+func TestParseVersionRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max string
+	}{
+		{"~1.2", "1.2.0", "1.3.0"},
+		{"~1.2.3", "1.2.3", "1.3.0"},
+		{"~1", "1.0.0", "2.0.0"},
+		{"^1.2", "1.2.0", "2.0.0"},
+		{"^1", "1.0.0", "2.0.0"},
+		{"^0.2.3", "0.2.3", "0.3.0"},
+		{"^0.0.3", "0.0.3", "0.0.4"},
+		{"^0", "0.0.0", "1.0.0"},
+	}
+	for _, tt := range tests {
+		r, err := parseVersionRange(tt.in)
+		if err != nil {
+			t.Errorf("parseVersionRange(%q) failed: %v", tt.in, err)
+			continue
+		}
+		min, _ := parseSemver(tt.min)
+		max, _ := parseSemver(tt.max)
+		if r.min.compare(min) != 0 || r.max.compare(max) != 0 {
+			t.Errorf("parseVersionRange(%q) = [%+v, %+v), want [%s, %s)", tt.in, r.min, r.max, tt.min, tt.max)
+		}
+	}
+
+	for _, in := range []string{"1.2", "~", "^v1", "~1.2-rc.1", "~latest"} {
+		if _, err := parseVersionRange(in); err == nil {
+			t.Errorf("parseVersionRange(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestHighestMatch(t *testing.T) {
+	tags := []string{"latest", "1.1.0", "v1.2.0", "1.2.5", "1.2.6-rc.1", "1.3.0", "2.0.0"}
+	tests := []struct {
+		expr string
+		want string
+		ok   bool
+	}{
+		{"~1.2", "1.2.5", true},
+		{"^1", "1.3.0", true},
+		{"~1.1", "1.1.0", true},
+		{"^2", "2.0.0", true},
+		{"~3", "", false},
+	}
+	for _, tt := range tests {
+		r, err := parseVersionRange(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := r.highestMatch(tags)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("highestMatch(%s) = %q, %v; want %q, %v", tt.expr, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestVersionRangeReferences(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+
+	for _, tag := range []string{"1.1.0", "1.2.0", "1.2.5", "1.3.0", "2.0.0-rc.1"} {
+		deck := writeDeckFile(t, []string{"2c"})
+		if tag == "1.2.5" {
+			deck = writeDeckFile(t, []string{"ad", "kh"})
+		}
+		if err := pushDeck(ctx, fmt.Sprintf("%s/deck:%s", addr, tag), deck, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for expr, want := range map[string]string{"~1.2": "1.2.5", "^1": "1.3.0", "~1.1.0": "1.1.0"} {
+		_, tag, err := openDeck(ctx, fmt.Sprintf("%s/deck:%s", addr, expr), opts)
+		if err != nil {
+			t.Fatalf("openDeck(%s) failed: %v", expr, err)
+		}
+		if tag != want {
+			t.Errorf("%s resolved to %s, want %s", expr, tag, want)
+		}
+	}
+	if _, _, err := openDeck(ctx, fmt.Sprintf("%s/deck:^2", addr), opts); err == nil {
+		t.Error("expected ^2 to match nothing (only a prerelease exists)")
+	}
+
+	// Copying a range keeps the concrete tag it resolved to.
+	layout := filepath.Join(t.TempDir(), "layout")
+	if err := copyDeck(ctx, fmt.Sprintf("%s/deck:~1.2", addr), "oci:"+layout, opts, pushOptions{}); err != nil {
+		t.Fatalf("copyDeck(~1.2) failed: %v", err)
+	}
+	src, tag, err := openDeck(ctx, "oci:"+layout+":~1", opts)
+	if err != nil {
+		t.Fatalf("openDeck on layout range failed: %v", err)
+	}
+	if tag != "1.2.5" {
+		t.Errorf("layout range resolved to %s, want 1.2.5", tag)
+	}
+	if _, err := loadDeck(ctx, src, tag); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "export")
+	if err := exportDeck(ctx, fmt.Sprintf("%s/deck:~1.2", addr), out, opts); err != nil {
+		t.Fatalf("exportDeck failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "deck.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cards []string
+	if err := json.Unmarshal(data, &cards); err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 || cards[0] != "ad" || cards[1] != "kh" {
+		t.Errorf("exported cards = %v, want [ad kh]", cards)
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("export wrote %d files, want deck.json and 2 images", len(entries))
+	}
+}
+
+func TestVersionRangeDestinationsRefused(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+	deck := writeDeckFile(t, []string{"2c"})
+	source := fmt.Sprintf("%s/deck:1.2.0", addr)
+	if err := pushDeck(ctx, source, deck, "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pushDeck(ctx, fmt.Sprintf("%s/deck:~1.2", addr), deck, "PNG-cards-1.3", opts, pushOptions{}); err == nil {
+		t.Error("expected pushing to a version range to fail")
+	}
+	layout := filepath.Join(t.TempDir(), "layout")
+	if err := run([]string{"--deck", deck, "--local", layout, "--target", addr + "/deck:^2"}); err == nil {
+		t.Error("expected saving under a version range to fail")
+	}
+	if err := copyDeck(ctx, source, fmt.Sprintf("%s/copy:^1", addr), opts, pushOptions{}); err == nil {
+		t.Error("expected copying to a version range to fail")
+	}
+
+	repo, err := opts.newRepository(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Resolve(ctx, "latest"); err == nil {
+		t.Error("a refused write still tagged latest")
+	}
+}
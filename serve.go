@@ -1,88 +1,185 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2"
 	"oras.land/oras-go/v2/content"
-	"oras.land/oras-go/v2/content/oci"
-	"oras.land/oras-go/v2/registry/remote"
-	"oras.land/oras-go/v2/registry/remote/auth"
-	"oras.land/oras-go/v2/registry/remote/credentials"
 )
 
 type deckServer struct {
 	cards  []string
 	images map[string][]byte
+
+	manifestDesc  ocispec.Descriptor
+	manifest      ocispec.Manifest
+	manifestBytes []byte
+	configBytes   []byte
+	base          string // URL path the deck is served under, "" for the root
+
+	cache        *blobCache          // images not in images are fetched through it; may be nil
+	src          oras.ReadOnlyTarget // where the deck was loaded from; nil if unknown
+	tag          string              // tag the deck was loaded by, "" if by digest
+	loadDuration time.Duration
+	graphMu      sync.Mutex
+	graph        *deckGraph // referrers, listed on first use and again once stale
+	graphLoads   flightGroup[struct{}, *deckGraph]
 }
 
-// openDeck opens a local OCI layout directory or a remote registry reference.
-func openDeck(ctx context.Context, source string, plainHTTP bool) (oras.ReadOnlyTarget, string, error) {
-	info, err := os.Stat(source)
-	if err == nil && info.IsDir() {
-		store, err := oci.NewWithContext(ctx, source)
-		if err != nil {
-			return nil, "", fmt.Errorf("opening OCI layout %s: %w", source, err)
-		}
-		return store, "latest", nil
+// openDeck opens the deck named by source (see parseDeckRef) and returns it
+// with the tag or digest to load. Layouts default to "latest", archives to
+// their only tag. When source names both a tag and a digest, the tag must
+// point at the digest and the digest is returned; a version range resolves to
+// the highest matching tag. With opts.lock set, source must match its
+// lockfile entry and the pinned digest is returned.
+func openDeck(ctx context.Context, source string, opts registryOptions) (oras.ReadOnlyTarget, string, error) {
+	if opts.lock != nil {
+		return opts.lock.open(ctx, source, opts)
 	}
-
-	tag := parseRef(source)
-	repo, err := remote.NewRepository(source)
+	ref, err := parseDeckRef(source)
 	if err != nil {
 		return nil, "", fmt.Errorf("invalid source reference: %w", err)
 	}
-	repo.PlainHTTP = plainHTTP
-
-	credStore, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	src, def, err := openDeckTarget(ctx, ref, opts)
 	if err != nil {
-		return nil, "", fmt.Errorf("loading docker credentials: %w", err)
+		return nil, "", err
+	}
+
+	if ref.Range != "" {
+		tag, err := resolveVersionRange(ctx, src, ref.Range)
+		if err != nil {
+			return nil, "", err
+		}
+		desc, err := src.Resolve(ctx, tag)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolving tag %q: %w", tag, err)
+		}
+		if !opts.quiet {
+			// Reported on stderr so JSON output on stdout stays parseable.
+			fmt.Fprintf(os.Stderr, "Resolved %s to %s (%s)\n", ref.Range, tag, desc.Digest)
+		}
+		return src, tag, nil
 	}
-	repo.Client = &auth.Client{
-		Cache:      auth.NewCache(),
-		Credential: credentials.Credential(credStore),
+	if err := ref.verifyTag(ctx, src); err != nil {
+		return nil, "", err
 	}
+	return src, ref.referenceOr(def), nil
+}
 
-	return repo, tag, nil
+// openDeckTarget opens the layout, archive or repository ref names, along with
+// the tag to load when ref gives none.
+func openDeckTarget(ctx context.Context, ref deckRef, opts registryOptions) (oras.ReadOnlyTarget, string, error) {
+	switch ref.Transport {
+	case transportOCI:
+		store, err := openLayout(ctx, ref.Location)
+		if err != nil {
+			return nil, "", err
+		}
+		return store, "latest", nil
+	case transportArchive:
+		store, err := openArchive(ctx, ref.Location)
+		if err != nil {
+			return nil, "", err
+		}
+		def, err := archiveTag(ctx, store)
+		if err != nil {
+			return nil, "", err
+		}
+		return store, def, nil
+	}
+	repo, err := opts.newRepository(ref.Location)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid source reference: %w", err)
+	}
+	return repo, "latest", nil
 }
 
-// loadDeck fetches the manifest, config, and image layers from an OCI source.
-func loadDeck(ctx context.Context, src oras.ReadOnlyTarget, tag string) (*deckServer, error) {
-	desc, err := src.Resolve(ctx, tag)
+// fetchManifest resolves ref in src and decodes the deck manifest it points to.
+func fetchManifest(ctx context.Context, src oras.ReadOnlyTarget, ref string) (ocispec.Descriptor, ocispec.Manifest, error) {
+	desc, manifest, _, err := fetchRawManifest(ctx, src, ref)
+	return desc, manifest, err
+}
+
+// fetchRawManifest is fetchManifest that also returns the manifest bytes.
+func fetchRawManifest(ctx context.Context, src oras.ReadOnlyTarget, ref string) (ocispec.Descriptor, ocispec.Manifest, []byte, error) {
+	desc, err := src.Resolve(ctx, ref)
 	if err != nil {
-		return nil, fmt.Errorf("resolving tag %q: %w", tag, err)
+		return ocispec.Descriptor{}, ocispec.Manifest{}, nil, fmt.Errorf("resolving tag %q: %w", ref, err)
 	}
 
 	manifestBytes, err := content.FetchAll(ctx, src, desc)
 	if err != nil {
-		return nil, fmt.Errorf("fetching manifest: %w", err)
+		return ocispec.Descriptor{}, ocispec.Manifest{}, nil, fmt.Errorf("fetching manifest: %w", err)
 	}
 
 	var manifest ocispec.Manifest
 	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
-		return nil, fmt.Errorf("unmarshaling manifest: %w", err)
+		return ocispec.Descriptor{}, ocispec.Manifest{}, nil, fmt.Errorf("unmarshaling manifest: %w", err)
 	}
+	return desc, manifest, manifestBytes, nil
+}
+
+// fetchCards fetches the deck config referenced by manifest and returns its
+// card shorthands.
+func fetchCards(ctx context.Context, src content.Fetcher, manifest ocispec.Manifest) ([]string, error) {
+	cards, _, err := fetchConfig(ctx, src, manifest)
+	return cards, err
+}
 
+// fetchConfig is fetchCards that also returns the config bytes.
+func fetchConfig(ctx context.Context, src content.Fetcher, manifest ocispec.Manifest) ([]string, []byte, error) {
 	configBytes, err := content.FetchAll(ctx, src, manifest.Config)
 	if err != nil {
-		return nil, fmt.Errorf("fetching config: %w", err)
+		return nil, nil, fmt.Errorf("fetching config: %w", err)
 	}
 
 	var cards []string
 	if err := json.Unmarshal(configBytes, &cards); err != nil {
-		return nil, fmt.Errorf("unmarshaling config: %w", err)
+		return nil, nil, fmt.Errorf("unmarshaling config: %w", err)
+	}
+	return cards, configBytes, nil
+}
+
+// loadDeck fetches the manifest, config, and image layers from an OCI source.
+func loadDeck(ctx context.Context, src oras.ReadOnlyTarget, tag string) (*deckServer, error) {
+	return loadDeckCached(ctx, src, tag, nil, false)
+}
+
+// loadDeckCached is loadDeck taking image layers already in cache from there
+// instead of fetching them again. A lazy load fetches only the manifest and
+// config; images are fetched through cache when first served.
+func loadDeckCached(ctx context.Context, src oras.ReadOnlyTarget, tag string, cache *blobCache, lazy bool) (*deckServer, error) {
+	desc, manifest, manifestBytes, err := fetchRawManifest(ctx, src, tag)
+	if err != nil {
+		return nil, err
+	}
+	if at := deckArtifactType(manifest); at != artifactType {
+		return nil, fmt.Errorf("%s is %w (artifact type %q)", tag, errNotDeck, at)
+	}
+
+	cards, configBytes, err := fetchConfig(ctx, src, manifest)
+	if err != nil {
+		return nil, err
 	}
 
 	images := make(map[string][]byte)
 	for _, layer := range manifest.Layers {
+		if lazy {
+			break
+		}
 		if layer.MediaType != "image/png" {
 			continue
 		}
@@ -90,59 +187,232 @@ func loadDeck(ctx context.Context, src oras.ReadOnlyTarget, tag string) (*deckSe
 		if filename == "" {
 			continue
 		}
-		data, err := content.FetchAll(ctx, src, layer)
+		data, err := cache.fetch(ctx, src, layer)
 		if err != nil {
 			return nil, fmt.Errorf("fetching layer %s: %w", filename, err)
 		}
 		images[filename] = data
 	}
 
-	return &deckServer{cards: cards, images: images}, nil
+	return &deckServer{
+		cards:         cards,
+		images:        images,
+		manifestDesc:  desc,
+		manifest:      manifest,
+		manifestBytes: manifestBytes,
+		configBytes:   configBytes,
+		cache:         cache,
+	}, nil
 }
 
 //go:embed index.html
 var indexHTML string
 
-var indexTmpl = template.Must(template.New("index").Funcs(template.FuncMap{
-	"toFilename": func(s string) string {
-		f, err := shorthandToFilename(s)
-		if err != nil {
-			return ""
-		}
-		return f
-	},
-}).Parse(indexHTML))
+var indexTmpl = template.Must(template.New("index").Parse(indexHTML))
 
+// handleIndex shows every card, linking images by digest so they are only
+// downloaded again when a card's image changes.
 func (ds *deckServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	cards := make([]cardRef, len(ds.cards))
+	for i, c := range ds.cards {
+		cards[i] = cardRef{Shorthand: c, URL: ds.blobURL(c)}
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	indexTmpl.Execute(w, struct{ Cards []string }{ds.cards})
+	indexTmpl.Execute(w, struct{ Cards []cardRef }{cards})
 }
 
+// handleImage serves an image by filename. A reload may put another image
+// under the same name, so browsers revalidate it on every view.
 func (ds *deckServer) handleImage(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimPrefix(r.URL.Path, "/images/")
+	layer, _ := ds.imageLayer(func(l ocispec.Descriptor) bool { return l.Annotations[ocispec.AnnotationTitle] == name })
 	data, ok := ds.images[name]
+	if !ok && layer.Digest != "" {
+		var err error
+		if data, err = ds.layerData(r.Context(), layer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		ok = true
+	}
 	if !ok {
 		http.NotFound(w, r)
 		return
 	}
+	w.Header().Set("Cache-Control", "no-cache")
+	serveImage(w, r, layer, data)
+}
+
+// handleBlob serves an image by digest. The URL names the content, so
+// browsers may keep it for good.
+func (ds *deckServer) handleBlob(w http.ResponseWriter, r *http.Request) {
+	dgst, err := digest.Parse(r.PathValue("digest"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	layer, ok := ds.imageLayer(func(l ocispec.Descriptor) bool { return l.Digest == dgst })
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := ds.layerData(r.Context(), layer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	serveImage(w, r, layer, data)
+}
+
+// serveImage writes data with the digest of layer, if known, as its ETag,
+// answering conditional and range requests.
+func serveImage(w http.ResponseWriter, r *http.Request, layer ocispec.Descriptor, data []byte) {
 	w.Header().Set("Content-Type", "image/png")
-	w.Write(data)
+	if layer.Digest != "" {
+		w.Header().Set("ETag", `"`+layer.Digest.String()+`"`)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// imageLayer returns the first image layer of the deck that match accepts.
+func (ds *deckServer) imageLayer(match func(ocispec.Descriptor) bool) (ocispec.Descriptor, bool) {
+	for _, layer := range ds.manifest.Layers {
+		if layer.MediaType == "image/png" && match(layer) {
+			return layer, true
+		}
+	}
+	return ocispec.Descriptor{}, false
+}
+
+// layerData returns the contents of an image layer, fetching it from the
+// source if the deck was loaded lazily.
+func (ds *deckServer) layerData(ctx context.Context, layer ocispec.Descriptor) ([]byte, error) {
+	if data, ok := ds.images[layer.Annotations[ocispec.AnnotationTitle]]; ok {
+		return data, nil
+	}
+	_, data, err := ds.fetchFromSource(ctx, ds.cache, layer)
+	return data, err
 }
 
-// serveDeck loads a deck from a local OCI layout or remote registry and serves it over HTTP.
-func serveDeck(ctx context.Context, source string, plainHTTP bool) error {
-	src, tag, err := openDeck(ctx, source, plainHTTP)
+// pullDeck opens source and loads the deck it names, reporting progress and
+// retrying transient failures. Layers found in cache are not fetched again;
+// a lazy pull leaves them to be fetched on first use.
+func pullDeck(ctx context.Context, source string, opts registryOptions, cache *blobCache, lazy bool) (*deckServer, error) {
+	start := time.Now()
+	src, tag, err := openDeck(ctx, source, opts)
 	if err != nil {
+		return nil, err
+	}
+
+	prog := newProgress(os.Stdout, opts.progress, "fetched")
+	var ds *deckServer
+	err = opts.withRetry(ctx, "pull", prog, func() error {
+		ds, err = loadDeckCached(ctx, newProgressSource(src, prog), tag, cache, lazy)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	prog.finish()
+	ds.src, ds.tag = src, tagOf(tag)
+	if locked, ok := opts.lock.lookup(source); ok && locked.Tag != "" {
+		ds.tag = locked.Tag // loaded by the pinned digest
 	}
+	ds.loadDuration = time.Since(start)
+	return ds, nil
+}
 
-	ds, err := loadDeck(ctx, src, tag)
+// tagOf returns ref if it is a tag and "" if it is a digest.
+func tagOf(ref string) string {
+	if _, err := digest.Parse(ref); err == nil {
+		return ""
+	}
+	return ref
+}
+
+// serverHandler routes /metrics and, with registryAPI set, /v2/ ahead of the
+// deck pages, and records metrics for every request.
+func serverHandler(pages http.Handler, sources []deckSource, decks []*deckHandler, cache *blobCache, registryAPI bool) http.Handler {
+	metrics := newServerMetrics(sources, decks, cache)
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics)
+	if registryAPI {
+		mux.Handle("/v2/", newRegistryAPI(sources, decks))
+	}
+	mux.Handle("/", pages)
+	return metrics.instrument(mux)
+}
+
+// serveDecks serves the decks in sources over HTTP until SIGINT or SIGTERM:
+// a single deck at the root, several under /decks/{name}/ with a landing page
+// listing them. The listener is up while decks load so readiness probes can
+// report them. With serve.watchInterval set, a deck pushed to the same
+// reference later replaces the one being served without a restart. With
+// serve.registryAPI set, OCI clients can also pull the decks from /v2/.
+// Prometheus metrics are served at /metrics.
+func serveDecks(ctx context.Context, sources []deckSource, opts registryOptions, serve serveOptions) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no decks to serve")
+	}
+	tlsConfig, err := serve.tlsConfig()
 	if err != nil {
 		return err
 	}
+	if serve.watchInterval > 0 && opts.lock != nil {
+		return fmt.Errorf("--watch-interval cannot follow a deck pinned by --lockfile")
+	}
+	ln, err := net.Listen("tcp", serve.listen)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signalContext(ctx)
+	defer stop()
+
+	var handler http.Handler
+	var decks []*deckHandler
+	if len(sources) == 1 {
+		h := newDeckHandler()
+		handler, decks = h, []*deckHandler{h}
+	} else {
+		site := newDeckSite(sources)
+		handler, decks = site, site.decks
+	}
+	cache := newBlobCache(serve.cacheSize)
+	srv := newHTTPServer(serverHandler(handler, sources, decks, cache, serve.registryAPI), tlsConfig)
+	for _, h := range decks {
+		h.cache, h.lazy = cache, serve.lazyLayers
+		srv.RegisterOnShutdown(h.close)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- runServer(ctx, srv, ln) }()
 
-	fmt.Printf("Serving %d cards on http://localhost:8080\n", len(ds.cards))
-	http.HandleFunc("/", ds.handleIndex)
-	http.HandleFunc("/images/", ds.handleImage)
-	return http.ListenAndServe(":8080", nil)
+	for i, src := range sources {
+		if len(sources) > 1 {
+			fmt.Printf("Loading %s from %s\n", src.Name, src.Source)
+		}
+		ds, err := pullDeck(ctx, src.Source, opts, cache, serve.lazyLayers)
+		if err != nil {
+			stop()
+			<-errc
+			return fmt.Errorf("%s: %w", src.Source, err)
+		}
+		decks[i].store(ds)
+		if serve.watchInterval > 0 {
+			go watchDeck(ctx, src.Source, opts, decks[i], serve.watchInterval)
+		}
+	}
+	if len(sources) == 1 {
+		fmt.Printf("Serving %d cards on %s\n", len(decks[0].deck.Load().cards), serverURL(ln.Addr(), tlsConfig))
+	} else {
+		fmt.Printf("Serving %d decks on %s\n", len(sources), serverURL(ln.Addr(), tlsConfig))
+	}
+	if serve.registryAPI {
+		var names []string
+		for _, src := range sources {
+			names = append(names, src.Name)
+		}
+		fmt.Printf("Registry API at %s/v2/ (repositories: %s)\n", serverURL(ln.Addr(), tlsConfig), strings.Join(names, ", "))
+	}
+	return <-errc
 }
@@ -20,11 +20,11 @@ func TestServeDeckFromLocal(t *testing.T) {
 	outputDir := filepath.Join(t.TempDir(), "deck-layout")
 
 	ctx := context.Background()
-	if err := saveDeckLocal(ctx, outputDir, deckFile, "PNG-cards-1.3", "latest"); err != nil {
+	if err := saveDeckLocal(ctx, outputDir, deckFile, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
 		t.Fatalf("saveDeckLocal failed: %v", err)
 	}
 
-	src, tag, err := openDeck(ctx, outputDir, false)
+	src, tag, err := openDeck(ctx, outputDir, registryOptions{})
 	if err != nil {
 		t.Fatalf("openSource failed: %v", err)
 	}
@@ -87,11 +87,11 @@ func TestServeDeckFromRegistry(t *testing.T) {
 	ctx := context.Background()
 
 	target := fmt.Sprintf("%s/deck:v1", addr)
-	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", true); err != nil {
+	if err := pushDeck(ctx, target, deckFile, "PNG-cards-1.3", registryOptions{plainHTTP: true}, pushOptions{}); err != nil {
 		t.Fatalf("pushDeck failed: %v", err)
 	}
 
-	src, tag, err := openDeck(ctx, target, true)
+	src, tag, err := openDeck(ctx, target, registryOptions{plainHTTP: true})
 	if err != nil {
 		t.Fatalf("openSource failed: %v", err)
 	}
@@ -136,7 +136,7 @@ func TestLoadDeckTamperedBlob(t *testing.T) {
 	outputDir := filepath.Join(t.TempDir(), "deck-layout")
 
 	ctx := context.Background()
-	if err := saveDeckLocal(ctx, outputDir, deckFile, "PNG-cards-1.3", "latest"); err != nil {
+	if err := saveDeckLocal(ctx, outputDir, deckFile, "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
 		t.Fatalf("saveDeckLocal failed: %v", err)
 	}
 
@@ -169,7 +169,7 @@ func TestLoadDeckTamperedBlob(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	src, tag, err := openDeck(ctx, outputDir, false)
+	src, tag, err := openDeck(ctx, outputDir, registryOptions{})
 	if err != nil {
 		t.Fatalf("openSource failed: %v", err)
 	}
@@ -179,3 +179,104 @@ func TestLoadDeckTamperedBlob(t *testing.T) {
 		t.Fatal("expected error when loading deck with tampered blob, got nil")
 	}
 }
+
+func TestServeLazyLayers(t *testing.T) {
+	ctx := context.Background()
+	layout := filepath.Join(t.TempDir(), "deck-layout")
+	if err := saveDeckLocal(ctx, layout, writeDeckFile(t, []string{"2c", "ad"}), "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cache := newBlobCache(0)
+	ds, err := pullDeck(ctx, layout, registryOptions{progress: progressNone}, cache, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.images) != 0 || cache.snapshot().Misses != 0 {
+		t.Fatalf("lazy load fetched %d images", len(ds.images))
+	}
+
+	h := newDeckHandler()
+	h.cache = cache
+	h.store(ds)
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/images/ace_of_diamonds.png", nil))
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Fatalf("image = %d with %d bytes", w.Code, w.Body.Len())
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/images/king_of_hearts.png", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("image of a card not in the deck = %d, want 404", w.Code)
+	}
+
+	var s cacheStats
+	getJSON(t, h, "/api/v1/cache", &s)
+	if s.Misses != 1 || s.Hits != 1 || s.Entries != 1 {
+		t.Errorf("cache stats = %+v, want 1 miss, 1 hit and 1 entry", s)
+	}
+}
+
+func TestServeImageBlobs(t *testing.T) {
+	ctx := context.Background()
+	layout := filepath.Join(t.TempDir(), "deck-layout")
+	if err := saveDeckLocal(ctx, layout, writeDeckFile(t, []string{"2c", "ad"}), "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := pullDeck(ctx, layout, registryOptions{progress: progressNone}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newDeckHandler()
+	h.store(ds)
+	layer, _ := ds.cardLayer("ad")
+	blob := "/blobs/" + layer.Digest.String()
+	etag := `"` + layer.Digest.String() + `"`
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if page := get("/").Body.String(); !strings.Contains(page, `src="`+blob+`"`) {
+		t.Errorf("index does not link %s:\n%s", blob, page)
+	}
+
+	w := get(blob)
+	if w.Code != http.StatusOK || int64(w.Body.Len()) != layer.Size {
+		t.Fatalf("blob = %d with %d bytes, want 200 with %d", w.Code, w.Body.Len(), layer.Size)
+	}
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Errorf("ETag = %q, want %q", got, etag)
+	}
+	if got := w.Header().Get("Cache-Control"); !strings.Contains(got, "immutable") {
+		t.Errorf("Cache-Control = %q, want immutable", got)
+	}
+	if w := get(blob, "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("conditional GET = %d with %d bytes, want 304", w.Code, w.Body.Len())
+	}
+	w = get(blob, "Range", "bytes=1-3")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "PNG" {
+		t.Errorf("range = %d %q, want 206 \"PNG\"", w.Code, w.Body.String())
+	}
+	if got, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes 1-3/%d", layer.Size); got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+
+	// Filename URLs carry the ETag too but are revalidated.
+	w = get("/images/ace_of_diamonds.png", "If-None-Match", etag)
+	if w.Code != http.StatusNotModified || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("conditional GET by name = %d (Cache-Control %q), want 304 no-cache", w.Code, w.Header().Get("Cache-Control"))
+	}
+
+	for _, path := range []string{"/blobs/" + ds.manifest.Config.Digest.String(), "/blobs/sha256:0000", "/blobs/nonsense"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Errorf("%s = %d, want 404", path, w.Code)
+		}
+	}
+}
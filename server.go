@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultListen   = ":8080"
+	shutdownTimeout = 15 * time.Second
+)
+
+// serveOptions configures the HTTP server started by --serve.
+type serveOptions struct {
+	listen        string // address to listen on, as for net.Listen
+	tlsCert       string // PEM certificate; with tlsKey, serves HTTPS
+	tlsKey        string
+	watchInterval time.Duration // how often to check the source for a new deck; 0 never does
+	registryAPI   bool          // also serve the decks read-only under /v2/
+	lazyLayers    bool          // load only manifests and configs up front
+	cacheSize     int64         // bytes of layers to keep in memory; 0 is unbounded
+}
+
+// tlsConfig loads the configured key pair, or returns nil for plain HTTP.
+func (o serveOptions) tlsConfig() (*tls.Config, error) {
+	if o.tlsCert == "" && o.tlsKey == "" {
+		return nil, nil
+	}
+	if o.tlsCert == "" || o.tlsKey == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(o.tlsCert, o.tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS key pair: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// deckHandler routes requests to the deck being served. The server starts
+// before the deck is loaded so probes can watch it come up: until a deck is
+// stored, deck routes and /readyz answer 503 while /healthz answers 200.
+type deckHandler struct {
+	deck     atomic.Pointer[deckServer]
+	mux      *http.ServeMux
+	shuffles *shuffleStore
+	tables   *tableStore
+	base     string     // URL path the handler is mounted under, "" for the root
+	cache    *blobCache // shared with other decks on the same server; may be nil
+	lazy     bool       // fetch images on first request rather than at load
+
+	reloadErrors atomic.Int64 // failed checks for a new deck
+}
+
+func newDeckHandler() *deckHandler {
+	h := &deckHandler{mux: http.NewServeMux(), shuffles: newShuffleStore(), tables: newTableStore()}
+	h.mux.HandleFunc("/healthz", handleLive)
+	h.mux.HandleFunc("/readyz", h.handleReady)
+	h.mux.HandleFunc("/", h.withDeck((*deckServer).handleIndex))
+	h.mux.HandleFunc("/images/", h.withDeck((*deckServer).handleImage))
+	h.mux.HandleFunc("GET /blobs/{digest}", h.withDeck((*deckServer).handleBlob))
+	h.mux.HandleFunc("GET /api/v1/deck", h.withDeck((*deckServer).handleAPIDeck))
+	h.mux.HandleFunc("GET /api/v1/cards/{shorthand}", h.withDeck((*deckServer).handleAPICard))
+	h.mux.HandleFunc("GET /api/v1/manifest", h.withDeck((*deckServer).handleAPIManifest))
+	h.mux.HandleFunc("GET /api/v1/config", h.withDeck((*deckServer).handleAPIConfig))
+	h.mux.HandleFunc("GET /api/v1/openapi.json", handleOpenAPI)
+	h.mux.HandleFunc("GET /api/v1/cache", h.handleAPICache)
+	h.mux.HandleFunc("POST /api/v1/shuffles", h.withDeck(h.shuffles.handleCreate))
+	h.mux.HandleFunc("GET /api/v1/shuffles/{id}", h.shuffles.handleGet)
+	h.mux.HandleFunc("POST /api/v1/shuffles/{id}/deal", h.shuffles.handleDeal)
+	h.mux.HandleFunc("POST /api/v1/shuffles/{id}/draw", h.shuffles.handleDraw)
+	h.mux.HandleFunc("POST /api/v1/shuffles/{id}/discard", h.shuffles.handleDiscard)
+	h.mux.HandleFunc("POST /api/v1/shuffles/{id}/reset", h.shuffles.handleReset)
+	h.mux.HandleFunc("POST /api/v1/tables", h.withDeck(h.tables.handleCreate))
+	h.mux.HandleFunc("GET /api/v1/tables/{id}", h.tables.handleGet)
+	h.mux.HandleFunc("POST /api/v1/tables/{id}/players", h.tables.handleJoin)
+	h.mux.HandleFunc("POST /api/v1/tables/{id}/deal", h.tables.handleDeal)
+	h.mux.HandleFunc("POST /api/v1/tables/{id}/play", h.tables.handlePlay)
+	h.mux.HandleFunc("POST /api/v1/tables/{id}/flip", h.tables.handleFlip)
+	h.mux.HandleFunc("GET /api/v1/tables/{id}/events", h.tables.handleEvents)
+	return h
+}
+
+func (h *deckHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+	noteRoute(r)
+}
+
+// store makes ds the deck served by h.
+func (h *deckHandler) store(ds *deckServer) {
+	ds.base = h.base
+	h.deck.Store(ds)
+}
+
+// withDeck adapts a deckServer method to the current deck.
+func (h *deckHandler) withDeck(fn func(*deckServer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ds := h.deck.Load()
+		if ds == nil {
+			http.Error(w, "deck is still loading", http.StatusServiceUnavailable)
+			return
+		}
+		fn(ds, w, r)
+	}
+}
+
+// handleAPICache reports the layer cache, which is shared by every deck on
+// the server.
+func (h *deckHandler) handleAPICache(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.cache.snapshot())
+}
+
+// close ends long-lived responses such as event streams.
+func (h *deckHandler) close() {
+	h.tables.close()
+}
+
+// handleLive answers liveness probes: serving at all means alive.
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *deckHandler) handleReady(w http.ResponseWriter, r *http.Request) {
+	if h.deck.Load() == nil {
+		http.Error(w, "deck is still loading", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// newHTTPServer returns a server for h with timeouts suited to a small
+// read-mostly site.
+func newHTTPServer(h http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// runServer serves on ln until ctx is done, then shuts srv down, letting
+// in-flight requests finish for up to shutdownTimeout.
+func runServer(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ServeTLS(ln, "", "")
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	fmt.Println("Shutting down ...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serverURL returns a URL for browsing the server listening on addr.
+func serverURL(addr net.Addr, tlsConfig *tls.Config) string {
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return scheme + "://" + addr.String()
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM.
+func signalContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
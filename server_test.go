@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeckHandlerProbes(t *testing.T) {
+	h := newDeckHandler()
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	for path, want := range map[string]int{"/healthz": 200, "/readyz": 503, "/": 503, "/images/2_of_clubs.png": 503} {
+		if got := get(path); got != want {
+			t.Errorf("before load: GET %s = %d, want %d", path, got, want)
+		}
+	}
+
+	h.deck.Store(&deckServer{cards: []string{"2c"}, images: map[string][]byte{"2_of_clubs.png": []byte("png")}})
+	for path, want := range map[string]int{"/healthz": 200, "/readyz": 200, "/": 200, "/images/2_of_clubs.png": 200} {
+		if got := get(path); got != want {
+			t.Errorf("after load: GET %s = %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestRunServerGracefulShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := newHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	}), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- runServer(ctx, srv, ln) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resc <- result{string(body), err}
+	}()
+
+	<-started
+	cancel()
+	select {
+	case err := <-errc:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	res := <-resc
+	if res.err != nil || res.body != "finished" {
+		t.Errorf("in-flight request = %q, %v; want it to finish", res.body, res.err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("runServer = %v, want nil after shutdown", err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("listener still accepting after shutdown")
+	}
+}
+
+func TestRunServerTLS(t *testing.T) {
+	// Any key pair will do; the client below does not verify it.
+	_, certFile, keyFile := writeClientCert(t)
+	tlsConfig, err := serveOptions{tlsCert: certFile, tlsKey: keyFile}.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newDeckHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- runServer(ctx, newHTTPServer(h, tlsConfig), ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	u := serverURL(ln.Addr(), tlsConfig)
+	if !strings.HasPrefix(u, "https://") {
+		t.Fatalf("serverURL = %s, want https", u)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(u + "/healthz")
+	if err != nil {
+		t.Fatalf("GET over TLS failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("healthz over TLS = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestServeOptionsTLSConfigErrors(t *testing.T) {
+	if cfg, err := (serveOptions{}).tlsConfig(); cfg != nil || err != nil {
+		t.Errorf("no TLS flags = %v, %v; want plain HTTP", cfg, err)
+	}
+	if _, err := (serveOptions{tlsCert: "cert.pem"}).tlsConfig(); err == nil {
+		t.Error("expected error for --tls-cert without --tls-key")
+	}
+	if _, err := (serveOptions{tlsCert: "missing.pem", tlsKey: "missing-key.pem"}).tlsConfig(); err == nil {
+		t.Error("expected error for missing key pair files")
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"sync"
+)
+
+// maxShuffles bounds how many shuffled decks a server keeps; creating one
+// more forgets the oldest.
+const maxShuffles = 1000
+
+// cardRef identifies a card in API responses.
+type cardRef struct {
+	Shorthand string `json:"shorthand"`
+	URL       string `json:"url"`
+}
+
+// shuffle is one shuffled instance of a loaded deck. The same seed always
+// produces the same order, so a deal can be replayed from its seed.
+type shuffle struct {
+	id      string
+	seed    int64
+	deck    *deckServer
+	pile    []string // draw pile, top card first
+	out     map[string]int
+	discard []string
+}
+
+func newShuffle(id string, seed int64, deck *deckServer) *shuffle {
+	s := &shuffle{id: id, seed: seed, deck: deck}
+	s.reset()
+	return s
+}
+
+// reset gathers every card back and shuffles again with the original seed.
+func (s *shuffle) reset() {
+	s.pile = shuffleCards(s.deck.cards, s.seed)
+	s.out = make(map[string]int)
+	s.discard = nil
+}
+
+// shuffleCards returns a copy of cards in the order given by seed.
+func shuffleCards(cards []string, seed int64) []string {
+	shuffled := append([]string(nil), cards...)
+	r := mathrand.New(mathrand.NewPCG(uint64(seed), 0))
+	r.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// draw takes n cards off the top of the pile.
+func (s *shuffle) draw(n int) ([]string, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("cannot draw %d cards", n)
+	}
+	if n > len(s.pile) {
+		return nil, fmt.Errorf("only %d cards left", len(s.pile))
+	}
+	cards := s.pile[:n:n]
+	s.pile = s.pile[n:]
+	for _, c := range cards {
+		s.out[c]++
+	}
+	return cards, nil
+}
+
+// deal draws hands of size cards each, one card to each hand in turn.
+func (s *shuffle) deal(hands, size int) ([][]string, error) {
+	// Checked before multiplying so huge requests cannot overflow.
+	if hands > len(s.pile) || size > len(s.pile) || hands > len(s.pile)/size {
+		return nil, fmt.Errorf("only %d cards left", len(s.pile))
+	}
+	cards, err := s.draw(hands * size)
+	if err != nil {
+		return nil, err
+	}
+	dealt := make([][]string, hands)
+	for i, c := range cards {
+		dealt[i%hands] = append(dealt[i%hands], c)
+	}
+	return dealt, nil
+}
+
+// discardCards moves cards that were drawn or dealt to the discard pile.
+func (s *shuffle) discardCards(cards []string) error {
+	need := make(map[string]int)
+	for _, c := range cards {
+		need[c]++
+		if need[c] > s.out[c] {
+			return fmt.Errorf("card %s has not been drawn", c)
+		}
+	}
+	for _, c := range cards {
+		s.out[c]--
+	}
+	s.discard = append(s.discard, cards...)
+	return nil
+}
+
+type shuffleState struct {
+	ID        string `json:"id"`
+	Seed      int64  `json:"seed"`
+	Deck      string `json:"deck"` // manifest digest of the shuffled deck
+	Remaining int    `json:"remaining"`
+	Discarded int    `json:"discarded"`
+}
+
+func (s *shuffle) state() shuffleState {
+	return shuffleState{
+		ID:        s.id,
+		Seed:      s.seed,
+		Deck:      s.deck.manifestDesc.Digest.String(),
+		Remaining: len(s.pile),
+		Discarded: len(s.discard),
+	}
+}
+
+func (s *shuffle) refs(cards []string) []cardRef {
+	refs := make([]cardRef, len(cards))
+	for i, c := range cards {
+		refs[i] = cardRef{Shorthand: c, URL: s.deck.imageURL(c)}
+	}
+	return refs
+}
+
+// shuffleStore holds the shuffles of a server in memory.
+type shuffleStore struct {
+	mu       sync.Mutex
+	shuffles map[string]*shuffle
+	order    []string // creation order, oldest first
+}
+
+func newShuffleStore() *shuffleStore {
+	return &shuffleStore{shuffles: make(map[string]*shuffle)}
+}
+
+// randomSeed returns a seed for callers that did not pick one. It stays
+// below 2^53 so JSON clients can echo it back exactly.
+func randomSeed() int64 {
+	return mathrand.Int64N(1 << 53)
+}
+
+// create shuffles deck with seed, or with a random seed when seed is nil.
+func (st *shuffleStore) create(deck *deckServer, seed *int64) *shuffle {
+	id := newID()
+	var s *shuffle
+	if seed != nil {
+		s = newShuffle(id, *seed, deck)
+	} else {
+		s = newShuffle(id, randomSeed(), deck)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.order) >= maxShuffles {
+		delete(st.shuffles, st.order[0])
+		st.order = st.order[1:]
+	}
+	st.shuffles[id] = s
+	st.order = append(st.order, id)
+	return s
+}
+
+// with runs fn on the shuffle with id while holding the store lock.
+func (st *shuffleStore) with(id string, fn func(*shuffle) error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.shuffles[id]
+	if !ok {
+		return errShuffleNotFound
+	}
+	return fn(s)
+}
+
+var errShuffleNotFound = errors.New("no such shuffle")
+
+// decodeBody decodes an optional JSON request body into v.
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// writeShuffleError maps shuffle errors to API responses.
+func writeShuffleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errShuffleNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSONError(w, http.StatusConflict, err.Error())
+}
+
+func (st *shuffleStore) handleCreate(ds *deckServer, w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Seed *int64 `json:"seed"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := st.create(ds, req.Seed)
+	st.mu.Lock()
+	state := s.state()
+	st.mu.Unlock()
+	w.Header().Set("Location", ds.base+"/api/v1/shuffles/"+s.id)
+	writeJSON(w, http.StatusCreated, struct {
+		Shuffle shuffleState `json:"shuffle"`
+	}{state})
+}
+
+func (st *shuffleStore) handleGet(w http.ResponseWriter, r *http.Request) {
+	var resp struct {
+		Shuffle shuffleState `json:"shuffle"`
+		Discard []cardRef    `json:"discard"`
+	}
+	err := st.with(r.PathValue("id"), func(s *shuffle) error {
+		resp.Shuffle, resp.Discard = s.state(), s.refs(s.discard)
+		return nil
+	})
+	if err != nil {
+		writeShuffleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (st *shuffleStore) handleDeal(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Hands int `json:"hands"`
+		Cards int `json:"cards"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Hands < 1 || req.Cards < 1 {
+		writeJSONError(w, http.StatusBadRequest, "hands and cards must be at least 1")
+		return
+	}
+	var resp struct {
+		Shuffle shuffleState `json:"shuffle"`
+		Hands   [][]cardRef  `json:"hands"`
+	}
+	err := st.with(r.PathValue("id"), func(s *shuffle) error {
+		hands, err := s.deal(req.Hands, req.Cards)
+		if err != nil {
+			return err
+		}
+		for _, hand := range hands {
+			resp.Hands = append(resp.Hands, s.refs(hand))
+		}
+		resp.Shuffle = s.state()
+		return nil
+	})
+	if err != nil {
+		writeShuffleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (st *shuffleStore) handleDraw(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Count int `json:"count"`
+	}{Count: 1}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Count < 1 {
+		writeJSONError(w, http.StatusBadRequest, "count must be at least 1")
+		return
+	}
+	var resp struct {
+		Shuffle shuffleState `json:"shuffle"`
+		Cards   []cardRef    `json:"cards"`
+	}
+	err := st.with(r.PathValue("id"), func(s *shuffle) error {
+		cards, err := s.draw(req.Count)
+		if err != nil {
+			return err
+		}
+		resp.Shuffle, resp.Cards = s.state(), s.refs(cards)
+		return nil
+	})
+	if err != nil {
+		writeShuffleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (st *shuffleStore) handleDiscard(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cards []string `json:"cards"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var resp struct {
+		Shuffle shuffleState `json:"shuffle"`
+	}
+	err := st.with(r.PathValue("id"), func(s *shuffle) error {
+		if err := s.discardCards(req.Cards); err != nil {
+			return err
+		}
+		resp.Shuffle = s.state()
+		return nil
+	})
+	if err != nil {
+		writeShuffleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (st *shuffleStore) handleReset(w http.ResponseWriter, r *http.Request) {
+	var resp struct {
+		Shuffle shuffleState `json:"shuffle"`
+	}
+	err := st.with(r.PathValue("id"), func(s *shuffle) error {
+		s.reset()
+		resp.Shuffle = s.state()
+		return nil
+	})
+	if err != nil {
+		writeShuffleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestShuffleCards(t *testing.T) {
+	cards := []string{"2c", "3c", "4c", "5c", "6c", "7c", "8c", "9c", "10c", "jc", "qc", "kc", "ac"}
+	a := shuffleCards(cards, 42)
+	b := shuffleCards(cards, 42)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("seed 42 gave %v then %v", a, b)
+	}
+	if reflect.DeepEqual(a, shuffleCards(cards, 43)) {
+		t.Error("seeds 42 and 43 gave the same order")
+	}
+	sorted := slices.Clone(a)
+	slices.Sort(sorted)
+	want := slices.Clone(cards)
+	slices.Sort(want)
+	if !reflect.DeepEqual(sorted, want) {
+		t.Errorf("shuffle %v is not a permutation of %v", a, cards)
+	}
+	if cards[0] != "2c" {
+		t.Error("shuffleCards modified its input")
+	}
+}
+
+// postJSON sends body to path and decodes a 2xx response into v.
+func postJSON(t *testing.T, h http.Handler, path, body string, v any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	if v != nil && w.Code/100 == 2 {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("POST %s: decoding %q: %v", path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+type shuffleResponse struct {
+	Shuffle shuffleState `json:"shuffle"`
+	Hands   [][]cardRef  `json:"hands"`
+	Cards   []cardRef    `json:"cards"`
+	Discard []cardRef    `json:"discard"`
+}
+
+func TestShuffleAPI(t *testing.T) {
+	h, ds := newTestDeckHandler(t, []string{"2c", "ad", "kh", "qs", "jd", "10h", "9s", "8c"})
+
+	deal := func(seed string) [][]cardRef {
+		var created shuffleResponse
+		if code := postJSON(t, h, "/api/v1/shuffles", `{"seed": `+seed+`}`, &created); code != http.StatusCreated {
+			t.Fatalf("create shuffle = %d", code)
+		}
+		if created.Shuffle.Remaining != 8 || created.Shuffle.Deck != ds.manifestDesc.Digest.String() {
+			t.Errorf("new shuffle = %+v", created.Shuffle)
+		}
+		var dealt shuffleResponse
+		if code := postJSON(t, h, "/api/v1/shuffles/"+created.Shuffle.ID+"/deal", `{"hands": 2, "cards": 3}`, &dealt); code != http.StatusOK {
+			t.Fatalf("deal = %d", code)
+		}
+		if dealt.Shuffle.Remaining != 2 || len(dealt.Hands) != 2 || len(dealt.Hands[0]) != 3 || len(dealt.Hands[1]) != 3 {
+			t.Fatalf("deal = %+v, want 2 hands of 3 and 2 left", dealt)
+		}
+		return dealt.Hands
+	}
+	first := deal("7")
+	if again := deal("7"); !reflect.DeepEqual(first, again) {
+		t.Errorf("seed 7 dealt %v then %v", first, again)
+	}
+	for _, hand := range first {
+		for _, c := range hand {
+			if c.URL != ds.imageURL(c.Shorthand) || c.URL == "" {
+				t.Errorf("card %s has URL %q", c.Shorthand, c.URL)
+			}
+		}
+	}
+
+	var s shuffleResponse
+	postJSON(t, h, "/api/v1/shuffles", "", &s)
+	id := s.Shuffle.ID
+	if s.Shuffle.Seed == 0 {
+		t.Error("shuffle without a seed should report the random seed it used")
+	}
+
+	var drawn shuffleResponse
+	if code := postJSON(t, h, "/api/v1/shuffles/"+id+"/draw", `{"count": 2}`, &drawn); code != http.StatusOK || len(drawn.Cards) != 2 {
+		t.Fatalf("draw = %d %+v", code, drawn)
+	}
+	body := `{"cards": ["` + drawn.Cards[0].Shorthand + `"]}`
+	if code := postJSON(t, h, "/api/v1/shuffles/"+id+"/discard", body, &s); code != http.StatusOK || s.Shuffle.Discarded != 1 {
+		t.Errorf("discard = %d %+v", code, s.Shuffle)
+	}
+	if code := postJSON(t, h, "/api/v1/shuffles/"+id+"/discard", body, nil); code != http.StatusConflict {
+		t.Errorf("discarding a card twice = %d, want 409", code)
+	}
+	if code := postJSON(t, h, "/api/v1/shuffles/"+id+"/draw", `{"count": 7}`, nil); code != http.StatusConflict {
+		t.Errorf("overdraw = %d, want 409", code)
+	}
+
+	var got shuffleResponse
+	getJSON(t, h, "/api/v1/shuffles/"+id, &got)
+	if got.Shuffle.Remaining != 6 || len(got.Discard) != 1 || got.Discard[0].Shorthand != drawn.Cards[0].Shorthand {
+		t.Errorf("shuffle state = %+v", got)
+	}
+
+	if code := postJSON(t, h, "/api/v1/shuffles/"+id+"/reset", "", &s); code != http.StatusOK || s.Shuffle.Remaining != 8 || s.Shuffle.Discarded != 0 {
+		t.Errorf("reset = %d %+v", code, s.Shuffle)
+	}
+	var redrawn shuffleResponse
+	postJSON(t, h, "/api/v1/shuffles/"+id+"/draw", `{"count": 2}`, &redrawn)
+	if !reflect.DeepEqual(redrawn.Cards, drawn.Cards) {
+		t.Errorf("after reset drew %v, want %v again", redrawn.Cards, drawn.Cards)
+	}
+
+	if code := postJSON(t, h, "/api/v1/shuffles/missing/draw", "", nil); code != http.StatusNotFound {
+		t.Errorf("draw on unknown shuffle = %d, want 404", code)
+	}
+	if code := postJSON(t, h, "/api/v1/shuffles/"+id+"/deal", `{"hands": 0}`, nil); code != http.StatusBadRequest {
+		t.Errorf("deal of no hands = %d, want 400", code)
+	}
+	for _, body := range []string{`{"hands": 4294967296, "cards": 4294967296}`, `{"hands": 9223372036854775807, "cards": 2}`, `{"hands": 2, "cards": 9223372036854775807}`} {
+		if code := postJSON(t, h, "/api/v1/shuffles/"+id+"/deal", body, nil); code != http.StatusConflict {
+			t.Errorf("deal %s = %d, want 409", body, code)
+		}
+	}
+	getJSON(t, h, "/api/v1/shuffles/"+id, &got)
+	if got.Shuffle.Remaining != 6 {
+		t.Errorf("huge deals left %d cards, want 6", got.Shuffle.Remaining)
+	}
+	if _, err := (&shuffle{}).draw(-1); err == nil {
+		t.Error("expected drawing a negative count to fail")
+	}
+	if code := postJSON(t, h, "/api/v1/shuffles", `{"sed": 1}`, nil); code != http.StatusBadRequest {
+		t.Errorf("create with unknown field = %d, want 400", code)
+	}
+}
+
+func TestShuffleStoreEvictsOldest(t *testing.T) {
+	st := newShuffleStore()
+	ds := &deckServer{cards: []string{"2c"}}
+	first := st.create(ds, nil)
+	for i := 0; i < maxShuffles; i++ {
+		st.create(ds, nil)
+	}
+	if err := st.with(first.id, func(*shuffle) error { return nil }); err != errShuffleNotFound {
+		t.Errorf("oldest shuffle still present after %d more, err = %v", maxShuffles, err)
+	}
+	if len(st.shuffles) != maxShuffles {
+		t.Errorf("store holds %d shuffles, want %d", len(st.shuffles), maxShuffles)
+	}
+}
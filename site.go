@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// deckSource names one deck to serve.
+type deckSource struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// deckConfig is the format of a --serve-config file.
+type deckConfig struct {
+	Decks []deckSource `json:"decks"`
+}
+
+// Deck names are limited to what reads well in a URL path; derived names drop
+// everything else.
+var (
+	deckNamePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	deckNameStripped = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// parseServeArg parses a --serve value, "[name=]source". Without a name, one
+// is derived from the source.
+func parseServeArg(s string) deckSource {
+	if name, source, ok := strings.Cut(s, "="); ok {
+		return deckSource{Name: name, Source: source}
+	}
+	return deckSource{Name: defaultDeckName(s), Source: s}
+}
+
+// defaultDeckName derives a name from the last element of the location of
+// source and its tag or version range, as in "card-deck-1.0.0".
+func defaultDeckName(source string) string {
+	ref, err := parseDeckRef(source)
+	if err != nil {
+		return ""
+	}
+	name := strings.TrimSuffix(path.Base(filepath.ToSlash(ref.Location)), ".tar")
+	if tag := ref.Tag + ref.Range; tag != "" {
+		name += "-" + tag
+	}
+	return strings.TrimLeft(deckNameStripped.ReplaceAllString(name, ""), "._-")
+}
+
+// collectDeckSources gathers the decks to serve from --serve values, a
+// --serve-config file and every tag of a --serve-repo repository, in that
+// order. Tags of the repository that are not decks, such as signatures, are
+// skipped with a warning. Names must be unique.
+func collectDeckSources(ctx context.Context, serves []string, configPath, repo string, opts registryOptions) ([]deckSource, error) {
+	var sources []deckSource
+	for _, s := range serves {
+		sources = append(sources, parseServeArg(s))
+	}
+
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading deck config: %w", err)
+		}
+		var cfg deckConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing deck config %s: %w", configPath, err)
+		}
+		for _, d := range cfg.Decks {
+			if d.Name == "" {
+				d.Name = defaultDeckName(d.Source)
+			}
+			sources = append(sources, d)
+		}
+	}
+
+	if repo != "" {
+		r, err := openRepository(ctx, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := parseDeckRef(repo)
+		if err != nil {
+			return nil, err
+		}
+		var tags []string
+		err = opts.retryRead(ctx, "serve-repo", func() error {
+			tags = nil
+			if err := r.Tags(ctx, "", func(page []string) error {
+				tags = append(tags, page...)
+				return nil
+			}); err != nil {
+				return fmt.Errorf("listing tags: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			var manifest ocispec.Manifest
+			err := opts.retryRead(ctx, "serve-repo", func() (err error) {
+				if _, manifest, err = fetchManifest(ctx, r, tag); err != nil {
+					return fmt.Errorf("%s: %w", tag, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if at := deckArtifactType(manifest); at != artifactType {
+				fmt.Fprintf(os.Stderr, "warning: skipping tag %s: %v (artifact type %q)\n", tag, errNotDeck, at)
+				continue
+			}
+			tagged := deckRef{Transport: ref.Transport, Location: ref.Location, Tag: tag}
+			sources = append(sources, deckSource{Name: tag, Source: tagged.String()})
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range sources {
+		if !deckNamePattern.MatchString(d.Name) {
+			return nil, fmt.Errorf("invalid deck name %q for %s (use name=source)", d.Name, d.Source)
+		}
+		if seen[d.Name] {
+			return nil, fmt.Errorf("two decks are named %q (use name=source to tell them apart)", d.Name)
+		}
+		seen[d.Name] = true
+	}
+	return sources, nil
+}
+
+//go:embed landing.html
+var landingHTML string
+
+var landingTmpl = template.Must(template.New("landing").Parse(landingHTML))
+
+// deckSite serves several decks, each with its own pages, images and API
+// under /decks/{name}/, and a landing page listing them at the root.
+type deckSite struct {
+	sources []deckSource
+	decks   []*deckHandler
+	mux     *http.ServeMux
+}
+
+func newDeckSite(sources []deckSource) *deckSite {
+	s := &deckSite{sources: sources, mux: http.NewServeMux()}
+	for _, src := range sources {
+		h := newDeckHandler()
+		h.base = "/decks/" + src.Name
+		s.decks = append(s.decks, h)
+		s.mux.Handle(h.base+"/", http.StripPrefix(h.base, h))
+	}
+	s.mux.HandleFunc("/healthz", handleLive)
+	s.mux.HandleFunc("/readyz", s.handleReady)
+	s.mux.HandleFunc("GET /api/v1/decks", s.handleAPIDecks)
+	s.mux.HandleFunc("/{$}", s.handleLanding)
+	return s
+}
+
+func (s *deckSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+	noteRoute(r)
+}
+
+// siteDeck summarises one deck of a site; Cards and Digest are empty while
+// it loads.
+type siteDeck struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	URL    string `json:"url"`
+	Ready  bool   `json:"ready"`
+	Cards  int    `json:"cards"`
+	Digest string `json:"digest,omitempty"`
+}
+
+func (s *deckSite) summaries() []siteDeck {
+	out := make([]siteDeck, len(s.decks))
+	for i, h := range s.decks {
+		out[i] = siteDeck{Name: s.sources[i].Name, Source: s.sources[i].Source, URL: h.base + "/"}
+		if ds := h.deck.Load(); ds != nil {
+			out[i].Ready, out[i].Cards, out[i].Digest = true, len(ds.cards), ds.manifestDesc.Digest.String()
+		}
+	}
+	return out
+}
+
+func (s *deckSite) handleLanding(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	landingTmpl.Execute(w, struct{ Decks []siteDeck }{s.summaries()})
+}
+
+func (s *deckSite) handleAPIDecks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.summaries())
+}
+
+// handleReady reports ready once every deck has loaded.
+func (s *deckSite) handleReady(w http.ResponseWriter, r *http.Request) {
+	var loading []string
+	for i, h := range s.decks {
+		if h.deck.Load() == nil {
+			loading = append(loading, s.sources[i].Name)
+		}
+	}
+	if len(loading) > 0 {
+		http.Error(w, "still loading: "+strings.Join(loading, ", "), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+func TestDefaultDeckName(t *testing.T) {
+	tests := map[string]string{
+		"localhost:5000/games/card-deck:1.0.0": "card-deck-1.0.0",
+		"localhost:5000/card-deck:~1.2":        "card-deck-1.2",
+		"localhost:5000/card-deck":             "card-deck",
+		"oci-archive:decks.tar:v2":             "decks-v2",
+		"oci:./layouts/poker":                  "poker",
+	}
+	for in, want := range tests {
+		if got := defaultDeckName(in); got != want {
+			t.Errorf("defaultDeckName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCollectDeckSources(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	layout := filepath.Join(dir, "layout")
+	for _, tag := range []string{"v1", "v2"} {
+		if err := saveDeckLocal(ctx, "oci:"+layout+":"+tag, writeDeckFile(t, []string{"2c"}), "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A signature under a tag of its own is not served.
+	store, err := oci.New(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := store.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := oras.PackManifest(ctx, store, oras.PackManifestVersion1_1, "application/vnd.example.signature", oras.PackManifestOptions{Subject: &subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tag(ctx, sig, "v1.sig"); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "decks.json")
+	if err := os.WriteFile(config, []byte(`{"decks": [{"name": "poker", "source": "localhost:5000/poker:1"}, {"source": "localhost:5000/bridge:2"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := collectDeckSources(ctx, []string{"main=localhost:5000/deck:v1", "localhost:5000/deck:v2"}, config, "oci:"+layout, registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []deckSource{
+		{"main", "localhost:5000/deck:v1"},
+		{"deck-v2", "localhost:5000/deck:v2"},
+		{"poker", "localhost:5000/poker:1"},
+		{"bridge-2", "localhost:5000/bridge:2"},
+		{"v1", "oci:" + layout + ":v1"},
+		{"v2", "oci:" + layout + ":v2"},
+	}
+	if len(sources) != len(want) {
+		t.Fatalf("sources = %+v, want %+v", sources, want)
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Errorf("source %d = %+v, want %+v", i, sources[i], want[i])
+		}
+	}
+
+	if _, err := collectDeckSources(ctx, []string{"localhost:5000/deck:v1", "other.io/deck:v1"}, "", "", registryOptions{}); err == nil || !strings.Contains(err.Error(), "name=source") {
+		t.Errorf("duplicate names error = %v", err)
+	}
+	if _, err := collectDeckSources(ctx, []string{"bad/name=localhost:5000/deck:v1"}, "", "", registryOptions{}); err == nil {
+		t.Error("expected an invalid name to be refused")
+	}
+}
+
+func TestDeckSite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, cards := range map[string][]string{"a": {"2c", "ad"}, "b": {"2c", "kh"}} {
+		if err := saveDeckLocal(ctx, "oci:"+filepath.Join(dir, name), writeDeckFile(t, cards), "PNG-cards-1.3", "latest", pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	site := newDeckSite([]deckSource{{"a", "oci:" + filepath.Join(dir, "a")}, {"b", "oci:" + filepath.Join(dir, "b")}})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		site.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	cache := newBlobCache(0)
+	for i, h := range site.decks {
+		if code := get("/readyz").Code; code != http.StatusServiceUnavailable {
+			t.Errorf("readyz with %d of 2 decks loaded = %d, want 503", i, code)
+		}
+		ds, err := pullDeck(ctx, site.sources[i].Source, registryOptions{progress: progressNone}, cache, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.store(ds)
+	}
+	if code := get("/readyz").Code; code != http.StatusOK {
+		t.Errorf("readyz with all decks loaded = %d, want 200", code)
+	}
+
+	landing := get("/").Body.String()
+	for _, want := range []string{`href="/decks/a/"`, `href="/decks/b/"`} {
+		if !strings.Contains(landing, want) {
+			t.Errorf("landing page is missing %s", want)
+		}
+	}
+	kh, _ := site.decks[1].deck.Load().cardLayer("kh")
+	if page := get("/decks/b/").Body.String(); !strings.Contains(page, `src="/decks/b/blobs/`+kh.Digest.String()+`"`) {
+		t.Errorf("deck page does not link images under its prefix:\n%s", page)
+	}
+	if w := get("/decks/b/images/king_of_hearts.png"); w.Code != http.StatusOK {
+		t.Errorf("deck image = %d, want 200", w.Code)
+	}
+	if w := get("/decks/a/images/king_of_hearts.png"); w.Code != http.StatusNotFound {
+		t.Errorf("image from another deck = %d, want 404", w.Code)
+	}
+	var card cardInfo
+	getJSON(t, site, "/decks/a/api/v1/cards/ad", &card)
+	if card.URL != "/decks/a/images/ace_of_diamonds.png" {
+		t.Errorf("card URL = %q", card.URL)
+	}
+	var decks []siteDeck
+	getJSON(t, site, "/api/v1/decks", &decks)
+	if len(decks) != 2 || !decks[0].Ready || decks[1].Cards != 2 {
+		t.Errorf("decks = %+v", decks)
+	}
+
+	// The card both decks share is held once.
+	a, b := site.decks[0].deck.Load(), site.decks[1].deck.Load()
+	if &a.images["2_of_clubs.png"][0] != &b.images["2_of_clubs.png"][0] {
+		t.Error("2c is held twice instead of shared")
+	}
+	if n := cache.snapshot().Entries; n != 3 {
+		t.Errorf("cache holds %d blobs, want 3 distinct images", n)
+	}
+}
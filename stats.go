@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// blobUsage counts how many tagged decks reference one blob.
+type blobUsage struct {
+	Digest string `json:"digest"`
+	Kind   string `json:"kind"` // "manifest", "config" or "card"
+	Card   string `json:"card,omitempty"`
+	Size   int64  `json:"size"`
+	Refs   int    `json:"refs"`
+}
+
+// dedupStats summarises how much storage layer de-duplication saves across
+// every tag in a repository or layout.
+type dedupStats struct {
+	Source         string      `json:"source"`
+	Tags           int         `json:"tags"`
+	Manifests      int         `json:"manifests"`
+	LogicalSize    int64       `json:"logicalSize"`
+	PhysicalSize   int64       `json:"physicalSize"`
+	Savings        int64       `json:"savings"`
+	SavingsPercent float64     `json:"savingsPercent"`
+	TopCards       []blobUsage `json:"topCards"`
+	Blobs          []blobUsage `json:"blobs"`
+}
+
+// collectStats walks the manifest of every deck tag in source, once per
+// manifest however many tags point at it; tags that are not decks, such as
+// signatures, are skipped with a warning. The logical size is what the decks would
+// take stored independently; the physical size counts each distinct blob
+// once.
+func collectStats(ctx context.Context, source string, top int, opts registryOptions) (dedupStats, error) {
+	repo, err := openRepository(ctx, source, opts)
+	if err != nil {
+		return dedupStats{}, err
+	}
+
+	var tags []string
+	if err := repo.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	}); err != nil {
+		return dedupStats{}, fmt.Errorf("listing tags: %w", err)
+	}
+
+	stats := dedupStats{Source: source, TopCards: []blobUsage{}, Blobs: []blobUsage{}}
+	usage := make(map[string]*blobUsage)
+	count := func(desc ocispec.Descriptor, kind string) {
+		stats.LogicalSize += desc.Size
+		u, ok := usage[desc.Digest.String()]
+		if !ok {
+			u = &blobUsage{
+				Digest: desc.Digest.String(),
+				Kind:   kind,
+				Card:   desc.Annotations[cardAnnotation],
+				Size:   desc.Size,
+			}
+			usage[u.Digest] = u
+			stats.PhysicalSize += desc.Size
+			if kind == "manifest" {
+				stats.Manifests++
+			}
+		}
+		u.Refs++
+	}
+
+	for _, tag := range tags {
+		desc, manifest, err := fetchManifest(ctx, repo, tag)
+		if err != nil {
+			return dedupStats{}, fmt.Errorf("tag %s: %w", tag, err)
+		}
+		if at := deckArtifactType(manifest); at != artifactType {
+			fmt.Fprintf(os.Stderr, "warning: skipping tag %s: %v (artifact type %q)\n", tag, errNotDeck, at)
+			continue
+		}
+		stats.Tags++
+		if _, walked := usage[desc.Digest.String()]; walked {
+			continue // another tag of the same deck
+		}
+		count(desc, "manifest")
+		count(manifest.Config, "config")
+		for _, layer := range manifest.Layers {
+			count(layer, "card")
+		}
+	}
+
+	for _, u := range usage {
+		stats.Blobs = append(stats.Blobs, *u)
+	}
+	sort.Slice(stats.Blobs, func(i, j int) bool {
+		a, b := stats.Blobs[i], stats.Blobs[j]
+		if a.Refs != b.Refs {
+			return a.Refs > b.Refs
+		}
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Digest < b.Digest
+	})
+	for _, u := range stats.Blobs {
+		if len(stats.TopCards) >= top {
+			break
+		}
+		if u.Kind == "card" && u.Refs > 1 {
+			stats.TopCards = append(stats.TopCards, u)
+		}
+	}
+
+	stats.Savings = stats.LogicalSize - stats.PhysicalSize
+	if stats.LogicalSize > 0 {
+		stats.SavingsPercent = float64(stats.Savings) / float64(stats.LogicalSize) * 100
+	}
+	return stats, nil
+}
+
+// printStats writes stats to w as text or JSON.
+func printStats(w io.Writer, stats dedupStats, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	case "text", "":
+	default:
+		return fmt.Errorf("unknown output format %q (want text or json)", format)
+	}
+
+	fmt.Fprintf(w, "%s: %d tags, %d manifests, %d blobs\n", stats.Source, stats.Tags, stats.Manifests, len(stats.Blobs))
+	fmt.Fprintf(w, "Logical size:  %s\n", formatBytes(stats.LogicalSize))
+	fmt.Fprintf(w, "Physical size: %s\n", formatBytes(stats.PhysicalSize))
+	fmt.Fprintf(w, "Dedup savings: %s (%.1f%%)\n", formatBytes(stats.Savings), stats.SavingsPercent)
+	if len(stats.TopCards) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w, "\nMost shared cards:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CARD\tREFS\tSIZE\tSAVED\tDIGEST")
+	for _, u := range stats.TopCards {
+		saved := u.Size * int64(u.Refs-1)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", u.Card, u.Refs, formatBytes(u.Size), formatBytes(saved), u.Digest)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"oras.land/oras-go/v2"
+)
+
+func TestCollectStats(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+	repo := fmt.Sprintf("%s/deck", addr)
+
+	decks := map[string][]string{
+		"v1": {"2c", "ad"},
+		"v2": {"2c", "kh"},
+		"v3": {"2c", "ad", "qh"},
+	}
+	for tag, cards := range decks {
+		if err := pushDeck(ctx, repo+":"+tag, writeDeckFile(t, cards), "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A second tag on v1 adds no savings.
+	r, err := opts.newRepository(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := r.Resolve(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Tag(ctx, v1, "stable"); err != nil {
+		t.Fatal(err)
+	}
+	// A signature under a tag of its own is not a deck and is left out.
+	sig, err := oras.PackManifest(ctx, r, oras.PackManifestVersion1_1, "application/vnd.example.signature", oras.PackManifestOptions{Subject: &v1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Tag(ctx, sig, "v1.sig"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := collectStats(ctx, repo, 10, opts)
+	if err != nil {
+		t.Fatalf("collectStats failed: %v", err)
+	}
+	if stats.Tags != 4 || stats.Manifests != 3 {
+		t.Errorf("tags=%d manifests=%d, want 4 and 3", stats.Tags, stats.Manifests)
+	}
+	// 3 manifests, 3 configs and 4 distinct card images.
+	if len(stats.Blobs) != 10 {
+		t.Errorf("got %d blobs, want 10", len(stats.Blobs))
+	}
+
+	const twoClubs, aceDiamonds = 23590, 36810
+	// 2c is stored once instead of three times and ad once instead of twice.
+	if want := int64(2*twoClubs + aceDiamonds); stats.Savings != want {
+		t.Errorf("savings = %d, want %d", stats.Savings, want)
+	}
+	if stats.LogicalSize-stats.PhysicalSize != stats.Savings {
+		t.Errorf("savings %d != logical %d - physical %d", stats.Savings, stats.LogicalSize, stats.PhysicalSize)
+	}
+	if stats.SavingsPercent <= 0 || stats.SavingsPercent >= 100 {
+		t.Errorf("savings percent = %f", stats.SavingsPercent)
+	}
+
+	if len(stats.TopCards) != 2 {
+		t.Fatalf("top cards = %+v, want 2c and ad", stats.TopCards)
+	}
+	if stats.TopCards[0].Card != "2c" || stats.TopCards[0].Refs != 3 {
+		t.Errorf("most shared = %+v, want 2c with 3 refs", stats.TopCards[0])
+	}
+	if stats.TopCards[1].Card != "ad" || stats.TopCards[1].Refs != 2 {
+		t.Errorf("second most shared = %+v, want ad with 2 refs", stats.TopCards[1])
+	}
+
+	limited, err := collectStats(ctx, repo, 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited.TopCards) != 1 {
+		t.Errorf("top=1 returned %d cards", len(limited.TopCards))
+	}
+
+	var text bytes.Buffer
+	if err := printStats(&text, stats, "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"4 tags, 3 manifests, 10 blobs", "Logical size:", "Physical size:", "Dedup savings:", "Most shared cards:", "2c"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text output missing %q:\n%s", want, text.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printStats(&out, stats, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded dedupStats
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded.Savings != stats.Savings || len(decoded.Blobs) != len(stats.Blobs) {
+		t.Errorf("JSON round trip mismatch: %+v", decoded)
+	}
+}
+
+func TestCollectStatsLayout(t *testing.T) {
+	ctx := context.Background()
+	dir := writeTwoDeckLayout(t)
+
+	stats, err := collectStats(ctx, dir, 10, registryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Tags != 2 {
+		t.Errorf("tags = %d, want 2", stats.Tags)
+	}
+	if len(stats.TopCards) != 1 || stats.TopCards[0].Card != "2c" {
+		t.Errorf("top cards = %+v, want only 2c", stats.TopCards)
+	}
+	if stats.Savings != 23590 {
+		t.Errorf("savings = %d, want one copy of 2c (23590)", stats.Savings)
+	}
+}
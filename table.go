@@ -0,0 +1,481 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxTables bounds how many tables a server keeps; opening one more closes
+// the oldest.
+const maxTables = 100
+
+var (
+	errTableNotFound = errors.New("no such table")
+	errNotAtTable    = errors.New("not a player at this table")
+	errNotYourCard   = errors.New("only the player who played a card may flip it")
+)
+
+// newID returns a random identifier for shuffles, tables and players.
+func newID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+type player struct {
+	id   string // secret; it is what lets a client see this player's hand
+	name string
+	hand []string
+}
+
+type tableCard struct {
+	card   string
+	faceUp bool
+	player string // name of the player who played it
+}
+
+// table is a multiplayer session over one shuffle of a deck. Every change
+// bumps version and wakes the subscribers of the table.
+type table struct {
+	id string
+
+	mu         sync.Mutex
+	shuffle    *shuffle
+	players    []*player // in seating order
+	cards      []tableCard
+	version    int
+	lastAction string
+	subs       map[chan struct{}]bool
+	closed     bool
+}
+
+func newTable(id string, s *shuffle) *table {
+	return &table{id: id, shuffle: s, subs: make(map[chan struct{}]bool)}
+}
+
+// changed records action and wakes subscribers. t.mu must be held.
+func (t *table) changed(action string) {
+	t.version++
+	t.lastAction = action
+	for ch := range t.subs {
+		select {
+		case ch <- struct{}{}:
+		default: // already pending; the subscriber reads the latest state
+		}
+	}
+}
+
+func (t *table) player(id string) (*player, bool) {
+	for _, p := range t.players {
+		if p.id == id {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (t *table) join(name string) (*player, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if name == "" {
+		return nil, errors.New("a player needs a name")
+	}
+	for _, p := range t.players {
+		if p.name == name {
+			return nil, fmt.Errorf("name %q is taken", name)
+		}
+	}
+	p := &player{id: newID(), name: name}
+	t.players = append(t.players, p)
+	t.changed(name + " joined")
+	return p, nil
+}
+
+// deal gives each seated player n more cards.
+func (t *table) deal(n int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.players) == 0 {
+		return errors.New("no players at the table")
+	}
+	hands, err := t.shuffle.deal(len(t.players), n)
+	if err != nil {
+		return err
+	}
+	for i, p := range t.players {
+		p.hand = append(p.hand, hands[i]...)
+	}
+	t.changed(fmt.Sprintf("dealt %d cards each", n))
+	return nil
+}
+
+// play moves card from the hand of player id onto the table.
+func (t *table) play(id, card string, faceUp bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.player(id)
+	if !ok {
+		return errNotAtTable
+	}
+	i := slices.Index(p.hand, card)
+	if i < 0 {
+		return fmt.Errorf("%s does not hold %s", p.name, card)
+	}
+	p.hand = slices.Delete(p.hand, i, i+1)
+	t.cards = append(t.cards, tableCard{card: card, faceUp: faceUp, player: p.name})
+	if faceUp {
+		t.changed(p.name + " played " + card)
+	} else {
+		t.changed(p.name + " played a card face down")
+	}
+	return nil
+}
+
+// flip turns over the table card at index for player id. Only the player who
+// played a card may turn it either way.
+func (t *table) flip(id string, index int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.player(id)
+	if !ok {
+		return errNotAtTable
+	}
+	if index < 0 || index >= len(t.cards) {
+		return fmt.Errorf("no card at position %d", index)
+	}
+	c := &t.cards[index]
+	if c.player != p.name {
+		return fmt.Errorf("%w: card %d was played by %s", errNotYourCard, index, c.player)
+	}
+	c.faceUp = !c.faceUp
+	if c.faceUp {
+		t.changed("flipped " + c.card)
+	} else {
+		t.changed(fmt.Sprintf("turned card %d face down", index))
+	}
+	return nil
+}
+
+// subscribe returns a channel that receives a value after each change. It
+// is closed when the table is.
+func (t *table) subscribe() chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	if t.closed {
+		close(ch)
+		return ch
+	}
+	t.subs[ch] = true
+	return ch
+}
+
+func (t *table) unsubscribe(ch chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.subs[ch] {
+		delete(t.subs, ch)
+		close(ch)
+	}
+}
+
+// close ends every event stream of the table.
+func (t *table) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for ch := range t.subs {
+		delete(t.subs, ch)
+		close(ch)
+	}
+}
+
+type seatView struct {
+	Name     string `json:"name"`
+	HandSize int    `json:"handSize"`
+}
+
+type selfView struct {
+	ID   string    `json:"id"`
+	Name string    `json:"name"`
+	Hand []cardRef `json:"hand"`
+}
+
+// tableCardView is a card on the table; face-down cards hide their identity.
+type tableCardView struct {
+	Shorthand string `json:"shorthand,omitempty"`
+	URL       string `json:"url,omitempty"`
+	FaceUp    bool   `json:"faceUp"`
+	Player    string `json:"player"`
+}
+
+// tableView is what one client may see of a table: every seat, the cards on
+// the table and only its own hand.
+type tableView struct {
+	ID         string          `json:"id"`
+	Version    int             `json:"version"`
+	LastAction string          `json:"lastAction,omitempty"`
+	Deck       string          `json:"deck"`
+	Remaining  int             `json:"remaining"`
+	Players    []seatView      `json:"players"`
+	Table      []tableCardView `json:"table"`
+	You        *selfView       `json:"you,omitempty"`
+}
+
+// view returns the table as seen by player id; an empty id is a spectator.
+func (t *table) view(id string) tableView {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v := tableView{
+		ID:         t.id,
+		Version:    t.version,
+		LastAction: t.lastAction,
+		Deck:       t.shuffle.deck.manifestDesc.Digest.String(),
+		Remaining:  len(t.shuffle.pile),
+		Players:    []seatView{},
+		Table:      []tableCardView{},
+	}
+	for _, p := range t.players {
+		v.Players = append(v.Players, seatView{Name: p.name, HandSize: len(p.hand)})
+		if p.id == id {
+			v.You = &selfView{ID: p.id, Name: p.name, Hand: t.shuffle.refs(p.hand)}
+		}
+	}
+	for _, c := range t.cards {
+		cv := tableCardView{FaceUp: c.faceUp, Player: c.player}
+		if c.faceUp {
+			cv.Shorthand, cv.URL = c.card, t.shuffle.deck.imageURL(c.card)
+		}
+		v.Table = append(v.Table, cv)
+	}
+	return v
+}
+
+// tableStore holds the tables of a server in memory.
+type tableStore struct {
+	mu     sync.Mutex
+	tables map[string]*table
+	order  []string // creation order, oldest first
+}
+
+func newTableStore() *tableStore {
+	return &tableStore{tables: make(map[string]*table)}
+}
+
+func (ts *tableStore) create(deck *deckServer, seed *int64) *table {
+	id := newID()
+	var s *shuffle
+	if seed != nil {
+		s = newShuffle(id, *seed, deck)
+	} else {
+		s = newShuffle(id, randomSeed(), deck)
+	}
+	t := newTable(id, s)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if len(ts.order) >= maxTables {
+		ts.tables[ts.order[0]].close()
+		delete(ts.tables, ts.order[0])
+		ts.order = ts.order[1:]
+	}
+	ts.tables[id] = t
+	ts.order = append(ts.order, id)
+	return t
+}
+
+func (ts *tableStore) get(id string) (*table, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t, ok := ts.tables[id]
+	if !ok {
+		return nil, errTableNotFound
+	}
+	return t, nil
+}
+
+// close ends every open event stream, so server shutdown need not wait for
+// browsers to disconnect.
+func (ts *tableStore) close() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, t := range ts.tables {
+		t.close()
+	}
+}
+
+// writeTableError maps table errors to API responses.
+func writeTableError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTableNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errNotAtTable), errors.Is(err, errNotYourCard):
+		writeJSONError(w, http.StatusForbidden, err.Error())
+	default:
+		writeJSONError(w, http.StatusConflict, err.Error())
+	}
+}
+
+func (ts *tableStore) handleCreate(ds *deckServer, w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Seed *int64 `json:"seed"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t := ts.create(ds, req.Seed)
+	w.Header().Set("Location", ds.base+"/api/v1/tables/"+t.id)
+	writeJSON(w, http.StatusCreated, t.view(""))
+}
+
+func (ts *tableStore) handleGet(w http.ResponseWriter, r *http.Request) {
+	t, err := ts.get(r.PathValue("id"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t.view(r.URL.Query().Get("player")))
+}
+
+func (ts *tableStore) handleJoin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := ts.get(r.PathValue("id"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	p, err := t.join(req.Name)
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, t.view(p.id))
+}
+
+func (ts *tableStore) handleDeal(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cards int `json:"cards"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Cards < 1 {
+		writeJSONError(w, http.StatusBadRequest, "cards must be at least 1")
+		return
+	}
+	t, err := ts.get(r.PathValue("id"))
+	if err == nil {
+		err = t.deal(req.Cards)
+	}
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t.view(r.URL.Query().Get("player")))
+}
+
+func (ts *tableStore) handlePlay(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Player string `json:"player"`
+		Card   string `json:"card"`
+		FaceUp *bool  `json:"faceUp"` // defaults to true
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	faceUp := req.FaceUp == nil || *req.FaceUp
+	t, err := ts.get(r.PathValue("id"))
+	if err == nil {
+		err = t.play(req.Player, req.Card, faceUp)
+	}
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t.view(req.Player))
+}
+
+func (ts *tableStore) handleFlip(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Player string `json:"player"`
+		Index  int    `json:"index"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := ts.get(r.PathValue("id"))
+	if err == nil {
+		err = t.flip(req.Player, req.Index)
+	}
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t.view(req.Player))
+}
+
+// handleEvents streams the table to one client as Server-Sent Events: the
+// current state on connect and again after every change. Changes that land
+// while a write is in progress are coalesced into the next event.
+func (ts *tableStore) handleEvents(w http.ResponseWriter, r *http.Request) {
+	t, err := ts.get(r.PathValue("id"))
+	if err != nil {
+		writeTableError(w, err)
+		return
+	}
+	id := r.URL.Query().Get("player")
+	if id != "" {
+		t.mu.Lock()
+		_, ok := t.player(id)
+		t.mu.Unlock()
+		if !ok {
+			writeTableError(w, errNotAtTable)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// Streams outlive the server's write timeout.
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := t.subscribe()
+	defer t.unsubscribe(ch)
+	for {
+		v := t.view(id)
+		data, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "id: %d\nevent: state\ndata: %s\n\n", v.Version, data)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		}
+	}
+}
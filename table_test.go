@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTableViews(t *testing.T) {
+	ds := &deckServer{cards: []string{"2c", "ad", "kh", "qs", "jd", "9s"}}
+	tbl := newTable("t1", newShuffle("t1", 3, ds))
+	alice, err := tbl.join("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := tbl.join("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.join("bob"); err == nil {
+		t.Error("expected a taken name to be refused")
+	}
+	if err := tbl.deal(2); err != nil {
+		t.Fatal(err)
+	}
+
+	av := tbl.view(alice.id)
+	if av.You == nil || av.You.Name != "alice" || len(av.You.Hand) != 2 {
+		t.Fatalf("alice sees %+v", av.You)
+	}
+	if len(av.Players) != 2 || av.Players[1].Name != "bob" || av.Players[1].HandSize != 2 || av.Remaining != 2 {
+		t.Errorf("alice sees seats %+v with %d left", av.Players, av.Remaining)
+	}
+	data, _ := json.Marshal(av)
+	for _, c := range bob.hand {
+		if strings.Contains(string(data), `"`+c+`"`) {
+			t.Errorf("alice's view %s reveals bob's %s", data, c)
+		}
+	}
+	if spectator := tbl.view(""); spectator.You != nil {
+		t.Errorf("spectator sees hand %+v", spectator.You)
+	}
+
+	hidden := alice.hand[0]
+	if err := tbl.play(alice.id, hidden, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.play(alice.id, hidden, true); err == nil {
+		t.Error("expected playing a card no longer held to fail")
+	}
+	if err := tbl.play("nobody", bob.hand[0], true); err != errNotAtTable {
+		t.Errorf("play by unknown player = %v, want errNotAtTable", err)
+	}
+	if bv := tbl.view(bob.id); bv.Table[0].Shorthand != "" || bv.Table[0].Player != "alice" {
+		t.Errorf("face-down card shown to bob as %+v", bv.Table[0])
+	}
+	if err := tbl.flip("", 0); err != errNotAtTable {
+		t.Errorf("flip by spectator = %v, want errNotAtTable", err)
+	}
+	if err := tbl.flip(bob.id, 0); !errors.Is(err, errNotYourCard) {
+		t.Errorf("flip of alice's card by bob = %v, want errNotYourCard", err)
+	}
+	if err := tbl.flip(alice.id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bv := tbl.view(bob.id); bv.Table[0].Shorthand != hidden || !bv.Table[0].FaceUp {
+		t.Errorf("flipped card shown to bob as %+v, want %s", bv.Table[0], hidden)
+	}
+	if err := tbl.flip(bob.id, 0); !errors.Is(err, errNotYourCard) {
+		t.Errorf("bob turning alice's card face down = %v, want errNotYourCard", err)
+	}
+	if bv := tbl.view(bob.id); !bv.Table[0].FaceUp {
+		t.Error("refused flip turned the card face down")
+	}
+	if err := tbl.flip(alice.id, 0); err != nil {
+		t.Errorf("alice turning her card face down: %v", err)
+	}
+	if bv := tbl.view(bob.id); bv.Table[0].FaceUp || bv.Table[0].Shorthand != "" {
+		t.Errorf("card turned face down shown to bob as %+v", bv.Table[0])
+	}
+	if err := tbl.flip(alice.id, 5); err == nil {
+		t.Error("expected flipping a missing card to fail")
+	}
+}
+
+func TestTableConcurrentPlayers(t *testing.T) {
+	cards := make([]string, 40)
+	for i := range cards {
+		cards[i] = "2c"
+	}
+	tbl := newTable("t1", newShuffle("t1", 1, &deckServer{cards: cards}))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := tbl.join(fmt.Sprintf("p%d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			tbl.deal(1)
+			tbl.view(p.id)
+		}()
+	}
+	wg.Wait()
+	v := tbl.view("")
+	total := v.Remaining
+	for _, p := range v.Players {
+		total += p.HandSize
+	}
+	if len(v.Players) != 8 || total != 40 {
+		t.Errorf("%d players hold and pile %d cards, want 8 and 40", len(v.Players), total)
+	}
+}
+
+// readEvent reads one Server-Sent Event and decodes its data.
+func readEvent(t *testing.T, r *bufio.Reader) tableView {
+	t.Helper()
+	var v tableView
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			if err := json.Unmarshal([]byte(data), &v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if line == "" {
+			return v
+		}
+	}
+}
+
+func TestTableEvents(t *testing.T) {
+	h, _ := newTestDeckHandler(t, []string{"2c", "ad", "kh", "qs", "jd", "9s"})
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	var created tableView
+	if code := postJSON(t, h, "/api/v1/tables", `{"seed": 9}`, &created); code != http.StatusCreated {
+		t.Fatalf("create table = %d", code)
+	}
+	base := "/api/v1/tables/" + created.ID
+	var alice, bob tableView
+	postJSON(t, h, base+"/players", `{"name": "alice"}`, &alice)
+	postJSON(t, h, base+"/players", `{"name": "bob"}`, &bob)
+	if alice.You == nil || bob.You == nil {
+		t.Fatalf("join responses missing player: %+v %+v", alice, bob)
+	}
+
+	resp, err := http.Get(ts.URL + base + "/events?player=" + alice.You.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("events content-type = %q", ct)
+	}
+	events := bufio.NewReader(resp.Body)
+	first := readEvent(t, events)
+	if first.You == nil || first.You.Name != "alice" || len(first.Players) != 2 {
+		t.Fatalf("first event = %+v", first)
+	}
+
+	if code := postJSON(t, h, base+"/deal", `{"cards": 2}`, nil); code != http.StatusOK {
+		t.Fatalf("deal = %d", code)
+	}
+	dealt := readEvent(t, events)
+	if dealt.Version <= first.Version || len(dealt.You.Hand) != 2 || dealt.Players[1].HandSize != 2 {
+		t.Errorf("event after deal = %+v", dealt)
+	}
+
+	card := dealt.You.Hand[0].Shorthand
+	body := fmt.Sprintf(`{"player": %q, "card": %q}`, alice.You.ID, card)
+	if code := postJSON(t, h, base+"/play", body, nil); code != http.StatusOK {
+		t.Fatalf("play = %d", code)
+	}
+	played := readEvent(t, events)
+	if len(played.Table) != 1 || played.Table[0].Shorthand != card || len(played.You.Hand) != 1 {
+		t.Errorf("event after play = %+v", played)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", base+"/events?player=nobody", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("events for unknown player = %d, want 403", w.Code)
+	}
+	if code := postJSON(t, h, base+"/flip", `{"index": 0}`, nil); code != http.StatusForbidden {
+		t.Errorf("flip without a player = %d, want 403", code)
+	}
+	if code := postJSON(t, h, base+"/deal", `{"cards": 9223372036854775807}`, nil); code != http.StatusConflict {
+		t.Errorf("deal of more cards than the pile holds = %d, want 409", code)
+	}
+	if code := postJSON(t, h, "/api/v1/tables/missing/deal", `{"cards": 1}`, nil); code != http.StatusNotFound {
+		t.Errorf("deal at unknown table = %d, want 404", code)
+	}
+
+	// Shutdown ends open streams.
+	h.close()
+	if _, err := io.ReadAll(events); err != nil {
+		t.Errorf("stream did not end cleanly: %v", err)
+	}
+}
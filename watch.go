@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchDeck re-resolves source every interval until ctx is done and swaps a
+// newly pushed deck into h. Failures are reported and retried on the next
+// tick; the current deck keeps being served meanwhile. Only reloads are
+// logged, not every check.
+func watchDeck(ctx context.Context, source string, opts registryOptions, h *deckHandler, interval time.Duration) {
+	opts.quiet = true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := reloadDeck(ctx, source, opts, h); err != nil && ctx.Err() == nil {
+			h.reloadErrors.Add(1)
+			fmt.Fprintf(os.Stderr, "warning: checking %s for updates: %v\n", source, err)
+		}
+	}
+}
+
+// reloadDeck loads source into h if it now resolves to a different manifest
+// than the deck h serves, and reports whether it did. Requests already being
+// handled keep the deck they started with.
+func reloadDeck(ctx context.Context, source string, opts registryOptions, h *deckHandler) (bool, error) {
+	start := time.Now()
+	src, ref, err := openDeck(ctx, source, opts)
+	if err != nil {
+		return false, err
+	}
+	desc, err := src.Resolve(ctx, ref)
+	if err != nil {
+		return false, fmt.Errorf("resolving %s: %w", source, err)
+	}
+	cur := h.deck.Load()
+	if cur != nil && cur.manifestDesc.Digest == desc.Digest {
+		return false, nil
+	}
+
+	// Load by digest so a tag moving again mid-load cannot mix two decks.
+	// Background reloads stay quiet apart from the transition itself.
+	prog := newProgress(os.Stdout, progressNone, "fetched")
+	var ds *deckServer
+	err = opts.withRetry(ctx, "pull", prog, func() error {
+		ds, err = loadDeckCached(ctx, src, desc.Digest.String(), h.cache, h.lazy)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	ds.base, ds.src, ds.tag = h.base, src, tagOf(ref)
+	ds.loadDuration = time.Since(start)
+	if !h.deck.CompareAndSwap(cur, ds) {
+		return false, nil // replaced concurrently
+	}
+	from := "nothing"
+	if cur != nil {
+		from = cur.manifestDesc.Digest.String()
+	}
+	if parsed, err := parseDeckRef(source); err == nil && parsed.Range != "" {
+		source = fmt.Sprintf("%s (now %s)", source, ds.tag)
+	}
+	fmt.Printf("Reloaded %s: %s -> %s (%d cards)\n", source, from, desc.Digest, len(ds.cards))
+	return true, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReloadDeck(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx := context.Background()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+	target := fmt.Sprintf("%s/deck:v1", addr)
+
+	if err := pushDeck(ctx, target, writeDeckFile(t, []string{"2c"}), "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	h := newDeckHandler()
+	if changed, err := reloadDeck(ctx, target, opts, h); err != nil || !changed {
+		t.Fatalf("initial reload = %v, %v; want a deck loaded", changed, err)
+	}
+	old := h.deck.Load()
+	if changed, err := reloadDeck(ctx, target, opts, h); err != nil || changed {
+		t.Errorf("reload with no new push = %v, %v; want no change", changed, err)
+	}
+
+	if err := pushDeck(ctx, target, writeDeckFile(t, []string{"ad", "kh"}), "PNG-cards-1.3", opts, pushOptions{force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := reloadDeck(ctx, target, opts, h); err != nil || !changed {
+		t.Fatalf("reload after push = %v, %v; want the new deck", changed, err)
+	}
+	if ds := h.deck.Load(); len(ds.cards) != 2 || ds.manifestDesc.Digest == old.manifestDesc.Digest {
+		t.Errorf("serving %v (%s) after reload", ds.cards, ds.manifestDesc.Digest)
+	}
+	// The replaced deck stays intact for requests that already hold it.
+	if len(old.cards) != 1 || len(old.images) != 1 {
+		t.Errorf("old deck changed to %v", old.cards)
+	}
+}
+
+func TestWatchDeck(t *testing.T) {
+	addr := setupRegistry(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := registryOptions{plainHTTP: true, progress: progressNone}
+	target := fmt.Sprintf("%s/deck:v1", addr)
+
+	if err := pushDeck(ctx, target, writeDeckFile(t, []string{"2c"}), "PNG-cards-1.3", opts, pushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	h := newDeckHandler()
+	if _, err := reloadDeck(ctx, target, opts, h); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		watchDeck(ctx, target, opts, h, 10*time.Millisecond)
+		close(done)
+	}()
+
+	if err := pushDeck(ctx, target, writeDeckFile(t, []string{"qs", "jd", "9s"}), "PNG-cards-1.3", opts, pushOptions{force: true}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.deck.Load().cards) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("watcher did not pick up the new deck")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/images/queen_of_spades.png", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("new card image = %d after reload, want 200", w.Code)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("watcher kept running after cancel")
+	}
+}